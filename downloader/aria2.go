@@ -7,9 +7,13 @@ import (
 	"errors"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/lonord/rss-torrent-downloader/poller"
@@ -19,6 +23,8 @@ type Aria2Downloader struct {
 	URL    string
 	Secret string
 	Dir    string
+	// listen to aria2 notifications over WebSocket, see Watch
+	WebSocket bool
 }
 
 type RPCRequest struct {
@@ -33,19 +39,17 @@ type RPCResponse struct {
 	Error map[string]interface{} `json:"error"`
 }
 
-type AddResponse struct {
-	RPCResponse
-	Result string `json:"result"`
-}
-
 type TellItem struct {
-	GID             string `json:"gid"`
-	Status          string `json:"status"`
-	CompletedLength string `json:"completedLength"`
-	TotalLength     string `json:"totalLength"`
-	DownloadSpeed   string `json:"downloadSpeed"`
-	InfoHash        string `json:"infoHash"`
-	Files           []File `json:"files"`
+	GID             string   `json:"gid"`
+	Status          string   `json:"status"`
+	CompletedLength string   `json:"completedLength"`
+	TotalLength     string   `json:"totalLength"`
+	DownloadSpeed   string   `json:"downloadSpeed"`
+	InfoHash        string   `json:"infoHash"`
+	FollowedBy      []string `json:"followedBy"`
+	ErrorMessage    string   `json:"errorMessage"`
+	Dir             string   `json:"dir"`
+	Files           []File   `json:"files"`
 }
 
 type File struct {
@@ -53,10 +57,11 @@ type File struct {
 	Length string `json:"length"`
 }
 
-type TellResponse struct {
-	RPCResponse
-	Result []TellItem `json:"result"`
-}
+// max number of calls sent in one system.multicall request
+const multicallSize = 100
+
+// page size of tellWaiting and tellStopped
+const tellPageSize = 1000
 
 func (d *Aria2Downloader) BatchDownload(ctx context.Context, works []*poller.Work) ([]DownloadResult, error) {
 	items, err := d.tellAll(ctx)
@@ -65,10 +70,23 @@ func (d *Aria2Downloader) BatchDownload(ctx context.Context, works []*poller.Wor
 	}
 	itemMap := make(map[string]*TellItem)
 	for _, item := range items {
+		if len(item.FollowedBy) > 0 {
+			// metadata task of a magnet link, the followed task reports the real status
+			continue
+		}
 		if _, alreadyExist := itemMap[item.InfoHash]; !alreadyExist || item.Status == "active" || item.Status == "waiting" || item.Status == "paused" {
 			itemMap[item.InfoHash] = &item
 		}
 	}
+	// adds and removes of all works are sent in batches, results are mapped
+	// back to the jobs afterwards
+	type pending struct {
+		work int
+		job  *poller.Job
+		item *TellItem
+	}
+	calls := []MultiCall{}
+	pendings := []pending{}
 	results := make([]DownloadResult, len(works))
 	for i, work := range works {
 		downOpts := map[string]interface{}{
@@ -77,32 +95,52 @@ func (d *Aria2Downloader) BatchDownload(ctx context.Context, works []*poller.Wor
 		for k, v := range work.Aria2Opt {
 			downOpts[k] = v
 		}
-		var r DownloadResult
+		r := &results[i]
 		for _, job := range work.Jobs {
 			item, ok := itemMap[job.InfoHash]
-			if !ok || item.Status == "error" {
-				if err := d.addTorrent(ctx, downOpts, job); err != nil {
-					log.Printf("aria2: add torrent %s@%s failed: %v\n", job.InfoHash, work.Name, err)
-					r.Failed++
-				} else {
-					log.Printf("aria2: add torrent %s@%s\n", job.InfoHash, work.Name)
-					r.Added++
+			if ok && item.Status == "error" {
+				// reported as failed, the job is added again when retried
+				log.Printf("aria2: task %s@%s error: %s\n", job.InfoHash, work.Name, item.ErrorMessage)
+				r.fail(job.InfoHash, "download error: "+item.ErrorMessage)
+				calls = append(calls, d.call("aria2.removeDownloadResult", item.GID))
+				pendings = append(pendings, pending{work: i, job: job, item: item})
+			} else if !ok {
+				call, err := d.addCall(downOpts, job)
+				if err != nil {
+					log.Printf("aria2: add %s %s@%s failed: %v\n", job.Type, job.InfoHash, work.Name, err)
+					r.fail(job.InfoHash, err.Error())
+					continue
 				}
+				calls = append(calls, call)
+				pendings = append(pendings, pending{work: i, job: job})
 			} else if item.Status == "complete" {
 				// remove task from aria2 server
-				if err := d.remove(ctx, item.GID); err != nil {
-					log.Printf("remove task from aria2c error: %s, gid: %s, infoHash: %s\n", err, item.GID, item.InfoHash)
-				} else {
-					r.Completed = append(r.Completed, job.InfoHash)
-					r.CompletedFiles = append(r.CompletedFiles, item.filePaths()...)
-				}
+				calls = append(calls, d.call("aria2.removeDownloadResult", item.GID))
+				pendings = append(pendings, pending{work: i, job: job, item: item})
 			} else if item.Status == "removed" {
 				r.Removed = append(r.Removed, job.InfoHash)
 			} else {
 				r.Running++
 			}
 		}
-		results[i] = r
+	}
+	errs := d.multicall(ctx, calls, nil)
+	for k, p := range pendings {
+		r, work, job := &results[p.work], works[p.work], p.job
+		switch {
+		case p.item != nil && errs[k] != nil:
+			log.Printf("remove task from aria2c error: %s, gid: %s, infoHash: %s\n", errs[k], p.item.GID, p.item.InfoHash)
+		case p.item != nil && p.item.Status == "complete":
+			r.complete(job.InfoHash, p.item.filePaths())
+		case p.item != nil:
+			// result of a failed task removed
+		case errs[k] != nil:
+			log.Printf("aria2: add %s %s@%s failed: %v\n", job.Type, job.InfoHash, work.Name, errs[k])
+			r.fail(job.InfoHash, errs[k].Error())
+		default:
+			log.Printf("aria2: add %s %s@%s\n", job.Type, job.InfoHash, work.Name)
+			r.addJob(job.InfoHash)
+		}
 	}
 	return results, nil
 }
@@ -110,71 +148,202 @@ func (d *Aria2Downloader) BatchDownload(ctx context.Context, works []*poller.Wor
 func (item *TellItem) filePaths() []string {
 	paths := make([]string, 0, len(item.Files))
 	for _, file := range item.Files {
-		if file.Path != "" {
-			paths = append(paths, filepath.Base(file.Path))
+		if file.Path == "" {
+			continue
+		}
+		// relative to the download directory, like the other downloaders
+		p := file.Path
+		if item.Dir != "" {
+			if rel, err := filepath.Rel(item.Dir, file.Path); err == nil && !strings.HasPrefix(rel, "..") {
+				p = rel
+			}
 		}
+		paths = append(paths, p)
 	}
 	return paths
 }
 
-func (d *Aria2Downloader) addTorrent(ctx context.Context, options map[string]interface{}, job *poller.Job) error {
-	options["follow-torrent"] = "mem"
+// addCall returns the call adding the job with a copy of options.
+func (d *Aria2Downloader) addCall(options map[string]interface{}, job *poller.Job) (MultiCall, error) {
+	options = maps.Clone(options)
 	options["seed-time"] = 0
-	req := d.newReq("aria2.addTorrent", job.Content, []string{}, options)
-	var resp AddResponse
-	err := d.rpcCall(ctx, req, &resp)
+	switch job.Type {
+	case "torrent":
+		options["follow-torrent"] = "mem"
+		return d.call("aria2.addTorrent", job.Content, []string{}, options), nil
+	case "magnet":
+		return d.call("aria2.addUri", []string{job.Content}, options), nil
+	default:
+		return MultiCall{}, errors.New("unsupported job type: " + job.Type)
+	}
+}
+
+// Remove stops the tasks of the given info hashes and drops their results,
+// aria2 has no way to delete the downloaded data.
+func (d *Aria2Downloader) Remove(ctx context.Context, infoHashes []string) error {
+	items, err := d.tellAll(ctx)
 	if err != nil {
 		return err
 	}
-	if resp.Error != nil {
-		return errors.New(resp.Error["message"].(string))
+	calls := []MultiCall{}
+	for _, item := range items {
+		if !slices.Contains(infoHashes, item.InfoHash) {
+			continue
+		}
+		if item.Status == "active" || item.Status == "waiting" || item.Status == "paused" {
+			calls = append(calls, d.call("aria2.forceRemove", item.GID))
+		} else {
+			calls = append(calls, d.call("aria2.removeDownloadResult", item.GID))
+		}
 	}
-	return nil
+	return errors.Join(d.multicall(ctx, calls, nil)...)
 }
 
-func (d *Aria2Downloader) remove(ctx context.Context, gid string) error {
-	req := d.newReq("aria2.removeDownloadResult", gid)
-	var resp AddResponse
-	err := d.rpcCall(ctx, req, &resp)
+// Progress returns the state of all bittorrent tasks of aria2, with the
+// status reported by aria2 (active, waiting, paused, error, complete or
+// removed).
+func (d *Aria2Downloader) Progress(ctx context.Context) ([]Progress, error) {
+	items, err := d.tellAll(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if resp.Error != nil {
-		return errors.New(resp.Error["message"].(string))
+	progress := []Progress{}
+	for _, item := range items {
+		if len(item.FollowedBy) > 0 {
+			continue
+		}
+		p := Progress{
+			InfoHash:  item.InfoHash,
+			Status:    item.Status,
+			Completed: parseLength(item.CompletedLength),
+			Total:     parseLength(item.TotalLength),
+			Speed:     parseLength(item.DownloadSpeed),
+		}
+		p.estimate()
+		progress = append(progress, p)
 	}
-	return nil
+	return progress, nil
+}
+
+// parseLength parses the decimal numbers aria2 reports as strings.
+func parseLength(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
 }
 
 func (d *Aria2Downloader) tellAll(ctx context.Context) ([]TellItem, error) {
-	columns := []string{"gid", "status", "completedLength", "totalLength", "downloadSpeed", "infoHash", "files"}
+	columns := []string{"gid", "status", "completedLength", "totalLength", "downloadSpeed", "infoHash", "followedBy", "errorMessage", "dir", "files"}
 	var items []TellItem
-	if err := d.rpcCallTell(ctx, d.newReq("aria2.tellActive", columns), &items); err != nil {
-		return nil, err
-	}
-	if err := d.rpcCallTell(ctx, d.newReq("aria2.tellWaiting", 0, 99999, columns), &items); err != nil {
-		return nil, err
+	// first pages in one request, further ones only if needed
+	pages := make([][]TellItem, 3)
+	errs := d.multicall(ctx, []MultiCall{
+		d.call("aria2.tellActive", columns),
+		d.call("aria2.tellWaiting", 0, tellPageSize, columns),
+		d.call("aria2.tellStopped", 0, tellPageSize, columns),
+	}, func(i int, result json.RawMessage) error {
+		return json.Unmarshal(result, &pages[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
 	}
-	if err := d.rpcCallTell(ctx, d.newReq("aria2.tellStopped", 0, 99999, columns), &items); err != nil {
-		return nil, err
+	items = appendTellItems(items, pages[0])
+	for i, method := range []string{"aria2.tellWaiting", "aria2.tellStopped"} {
+		page := pages[i+1]
+		for offset := 0; ; offset += tellPageSize {
+			items = appendTellItems(items, page)
+			if len(page) < tellPageSize {
+				break
+			}
+			page = nil
+			errs := d.multicall(ctx, []MultiCall{d.call(method, offset+tellPageSize, tellPageSize, columns)}, func(_ int, result json.RawMessage) error {
+				return json.Unmarshal(result, &page)
+			})
+			if errs[0] != nil {
+				return nil, errs[0]
+			}
+		}
 	}
 	return items, nil
 }
 
-func (d *Aria2Downloader) rpcCallTell(ctx context.Context, rpc *RPCRequest, items *[]TellItem) error {
-	var resp TellResponse
-	err := d.rpcCall(ctx, rpc, &resp)
-	if err != nil {
-		return err
-	}
-	if resp.Error != nil {
-		return errors.New(resp.Error["message"].(string))
-	}
-	for _, item := range resp.Result {
+// appendTellItems appends bittorrent tasks, other downloads have no info hash.
+func appendTellItems(items []TellItem, page []TellItem) []TellItem {
+	for _, item := range page {
 		if item.InfoHash != "" {
-			*items = append(*items, item)
+			items = append(items, item)
 		}
 	}
-	return nil
+	return items
+}
+
+// MultiCall is a call of a system.multicall request.
+type MultiCall struct {
+	Method string        `json:"methodName"`
+	Params []interface{} `json:"params"`
+}
+
+type multicallResponse struct {
+	RPCResponse
+	Result []json.RawMessage `json:"result"`
+}
+
+type multicallFault struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// multicall sends calls in batches of system.multicall and returns the error
+// of each call. decode is called with the result of each succeeded call if
+// not nil.
+func (d *Aria2Downloader) multicall(ctx context.Context, calls []MultiCall, decode func(i int, result json.RawMessage) error) []error {
+	errs := make([]error, len(calls))
+	for start := 0; start < len(calls); start += multicallSize {
+		batch := calls[start:min(start+multicallSize, len(calls))]
+		var resp multicallResponse
+		err := d.rpcCall(ctx, d.newMulticallReq(batch), &resp)
+		if err == nil && resp.Error != nil {
+			err = rpcError(resp.Error)
+		}
+		if err == nil && len(resp.Result) != len(batch) {
+			err = errors.New("unexpected multicall result count")
+		}
+		for i := range batch {
+			if err != nil {
+				errs[start+i] = err
+				continue
+			}
+			errs[start+i] = decodeMulticallResult(resp.Result[i], func(result json.RawMessage) error {
+				if decode == nil {
+					return nil
+				}
+				return decode(start+i, result)
+			})
+		}
+	}
+	return errs
+}
+
+// decodeMulticallResult unwraps a result, which is either a one element
+// array holding the value or a fault object.
+func decodeMulticallResult(raw json.RawMessage, decode func(result json.RawMessage) error) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(raw, &values); err == nil && len(values) == 1 {
+		return decode(values[0])
+	}
+	var fault multicallFault
+	if err := json.Unmarshal(raw, &fault); err != nil {
+		return err
+	}
+	return errors.New(fault.Message)
+}
+
+func rpcError(e map[string]interface{}) error {
+	if msg, ok := e["message"].(string); ok {
+		return errors.New(msg)
+	}
+	return errors.New("aria2 rpc error")
 }
 
 func (d *Aria2Downloader) rpcCall(ctx context.Context, rpc *RPCRequest, out interface{}) error {
@@ -203,15 +372,20 @@ func (d *Aria2Downloader) rpcCall(ctx context.Context, rpc *RPCRequest, out inte
 	return json.NewDecoder(resp.Body).Decode(out)
 }
 
-func (d *Aria2Downloader) newReq(method string, params ...interface{}) *RPCRequest {
-	params2 := params
+// call returns a call of a multicall, with the secret token if any.
+func (d *Aria2Downloader) call(method string, params ...interface{}) MultiCall {
 	if d.Secret != "" {
-		params2 = append([]interface{}{"token:" + d.Secret}, params...)
+		params = append([]interface{}{"token:" + d.Secret}, params...)
 	}
+	return MultiCall{Method: method, Params: params}
+}
+
+func (d *Aria2Downloader) newMulticallReq(calls []MultiCall) *RPCRequest {
+	// system.multicall itself takes no token
 	return &RPCRequest{
 		Version: "2.0",
 		ID:      uuid.New().String(),
-		Method:  method,
-		Params:  params2,
+		Method:  "system.multicall",
+		Params:  []interface{}{calls},
 	}
 }
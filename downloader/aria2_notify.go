@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// aria2 notifications which change the result of BatchDownload
+var aria2Notifications = []string{
+	"aria2.onDownloadComplete",
+	"aria2.onBtDownloadComplete",
+	"aria2.onDownloadError",
+}
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+type aria2Notification struct {
+	Method string `json:"method"`
+}
+
+// Watch listens to aria2 notifications over WebSocket and calls notify when
+// a task completed or failed, until ctx is done. The connection is retried
+// with an increasing delay, notify is also called after reconnecting since
+// notifications may have been missed meanwhile. Watch returns at once if
+// WebSocket is not enabled.
+func (d *Aria2Downloader) Watch(ctx context.Context, notify func()) {
+	if !d.WebSocket {
+		return
+	}
+	delay := minReconnectDelay
+	reconnect := false
+	for {
+		connected, err := d.watchOnce(ctx, notify, reconnect)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = minReconnectDelay
+			reconnect = true
+		}
+		log.Printf("aria2: websocket error: %s, reconnect in %s\n", err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (d *Aria2Downloader) watchOnce(ctx context.Context, notify func(), reconnect bool) (bool, error) {
+	wsURL, err := d.webSocketURL()
+	if err != nil {
+		return false, err
+	}
+	config, err := websocket.NewConfig(wsURL, d.URL)
+	if err != nil {
+		return false, err
+	}
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() {
+		ws.Close()
+	})
+	defer stop()
+	log.Printf("aria2: listening to notifications on %s\n", wsURL)
+	if reconnect {
+		notify()
+	}
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return true, err
+		}
+		var n aria2Notification
+		if err := json.Unmarshal(msg, &n); err != nil {
+			continue
+		}
+		for _, method := range aria2Notifications {
+			if n.Method == method {
+				notify()
+				break
+			}
+		}
+	}
+}
+
+// webSocketURL returns the WebSocket endpoint of the aria2 RPC server.
+func (d *Aria2Downloader) webSocketURL() (string, error) {
+	switch {
+	case strings.HasPrefix(d.URL, "http://"):
+		return "ws://" + strings.TrimPrefix(d.URL, "http://") + "/jsonrpc", nil
+	case strings.HasPrefix(d.URL, "https://"):
+		return "wss://" + strings.TrimPrefix(d.URL, "https://") + "/jsonrpc", nil
+	}
+	return "", errors.New("unsupported aria2 url: " + d.URL)
+}
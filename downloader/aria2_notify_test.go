@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestAria2Watch(t *testing.T) {
+	conns := make(chan *websocket.Conn)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		conns <- ws
+		// keep the connection open until the test closes it
+		var msg []byte
+		websocket.Message.Receive(ws, &msg)
+	}))
+	defer srv.Close()
+
+	d := &Aria2Downloader{URL: srv.URL, WebSocket: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	notified := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		d.Watch(ctx, func() { notified <- struct{}{} })
+		close(done)
+	}()
+
+	expectNotify := func(want bool) {
+		t.Helper()
+		select {
+		case <-notified:
+			if !want {
+				t.Error("unexpected notification")
+			}
+		case <-time.After(time.Millisecond * 200):
+			if want {
+				t.Error("notification not received")
+			}
+		}
+	}
+
+	ws := <-conns
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","method":"aria2.onDownloadStart","params":[{"gid":"1"}]}`)
+	expectNotify(false)
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","method":"aria2.onBtDownloadComplete","params":[{"gid":"1"}]}`)
+	expectNotify(true)
+
+	// a reconnect notifies once for missed notifications
+	ws.Close()
+	select {
+	case ws = <-conns:
+	case <-time.After(minReconnectDelay * 3):
+		t.Fatal("watch did not reconnect")
+	}
+	expectNotify(true)
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","method":"aria2.onDownloadError","params":[{"gid":"2"}]}`)
+	expectNotify(true)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch did not return after cancel")
+	}
+}
+
+func TestAria2WatchDisabled(t *testing.T) {
+	d := &Aria2Downloader{URL: "http://127.0.0.1:1"}
+	// returns at once without WebSocket enabled
+	d.Watch(context.Background(), func() { t.Error("unexpected notification") })
+}
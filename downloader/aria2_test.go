@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+// fakeAria2 serves system.multicall of the tell, add and remove methods.
+type fakeAria2 struct {
+	t        *testing.T
+	active   []TellItem
+	stopped  []TellItem
+	requests []string
+	removed  []string
+	added    []string
+}
+
+func (f *fakeAria2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string              `json:"method"`
+		Params [][]json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.t.Fatal(err)
+	}
+	f.requests = append(f.requests, req.Method)
+	if req.Method != "system.multicall" {
+		http.Error(w, "unexpected method "+req.Method, http.StatusBadRequest)
+		return
+	}
+	results := []interface{}{}
+	for _, raw := range req.Params[0] {
+		var call struct {
+			Method string            `json:"methodName"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.Unmarshal(raw, &call)
+		if len(call.Params) == 0 || string(call.Params[0]) != `"token:secret"` {
+			results = append(results, map[string]interface{}{"code": 1, "message": "Unauthorized"})
+			continue
+		}
+		params := call.Params[1:]
+		f.requests = append(f.requests, call.Method)
+		var arg string
+		if len(params) > 0 {
+			json.Unmarshal(params[0], &arg)
+		}
+		switch call.Method {
+		case "aria2.tellActive":
+			results = append(results, []interface{}{f.active})
+		case "aria2.tellWaiting":
+			results = append(results, []interface{}{[]TellItem{}})
+		case "aria2.tellStopped":
+			var offset, num int
+			json.Unmarshal(params[0], &offset)
+			json.Unmarshal(params[1], &num)
+			page := f.stopped[min(offset, len(f.stopped)):min(offset+num, len(f.stopped))]
+			results = append(results, []interface{}{page})
+		case "aria2.addTorrent":
+			if arg == "bad" {
+				results = append(results, map[string]interface{}{"code": 1, "message": "invalid torrent"})
+				continue
+			}
+			f.added = append(f.added, arg)
+			results = append(results, []interface{}{"gid"})
+		case "aria2.removeDownloadResult":
+			f.removed = append(f.removed, arg)
+			results = append(results, []interface{}{"OK"})
+		default:
+			results = append(results, map[string]interface{}{"code": 1, "message": "unknown method " + call.Method})
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": "1", "jsonrpc": "2.0", "result": results})
+}
+
+func TestAria2BatchDownloadMulticall(t *testing.T) {
+	f := &fakeAria2{t: t, active: []TellItem{{GID: "1", Status: "active", InfoHash: "aaaa"}}}
+	// more stopped tasks than one page
+	for i := 0; i < tellPageSize+5; i++ {
+		f.stopped = append(f.stopped, TellItem{GID: fmt.Sprintf("s%d", i), Status: "removed", InfoHash: fmt.Sprintf("old%d", i)})
+	}
+	f.stopped = append(f.stopped,
+		TellItem{GID: "2", Status: "complete", InfoHash: "bbbb", Dir: "/data/Show", Files: []File{{Path: "/data/Show/Season 1/Show - 02.mkv"}}},
+		TellItem{GID: "3", Status: "error", InfoHash: "eeee", ErrorMessage: "disk full"},
+	)
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	d := &Aria2Downloader{URL: srv.URL, Secret: "secret"}
+	works := []*poller.Work{{Name: "Show", Jobs: []*poller.Job{
+		{Type: "torrent", InfoHash: "aaaa", Content: "running"},
+		{Type: "torrent", InfoHash: "bbbb", Content: "completed"},
+		{Type: "torrent", InfoHash: "cccc", Content: "new"},
+		{Type: "torrent", InfoHash: "dddd", Content: "bad"},
+		{Type: "torrent", InfoHash: "eeee", Content: "error"},
+	}}}
+	results, err := d.BatchDownload(context.Background(), works)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if r.Running != 1 || !slices.Equal(r.AddedJobs, []string{"cccc"}) || !slices.Equal(r.Completed, []string{"bbbb"}) {
+		t.Errorf("result = %+v; want 1 running, cccc added, bbbb completed", r)
+	}
+	if r.FailedJobs["dddd"] != "invalid torrent" || r.FailedJobs["eeee"] != "download error: disk full" {
+		t.Errorf("failed jobs = %v; want dddd and eeee", r.FailedJobs)
+	}
+	if !slices.Equal(r.CompletedFiles, []string{"Show - 02.mkv"}) {
+		t.Errorf("completed files = %v", r.CompletedFiles)
+	}
+	if files := r.CompletedFileMap["bbbb"]; !slices.Equal(files, []string{"Season 1/Show - 02.mkv"}) {
+		t.Errorf("completed file map = %v; want paths relative to the download dir", r.CompletedFileMap)
+	}
+	if !slices.Equal(f.added, []string{"new"}) || !slices.Equal(f.removed, []string{"2", "3"}) {
+		t.Errorf("added = %v, removed = %v", f.added, f.removed)
+	}
+	// tell requests, one more tellStopped page, then adds and removes
+	multicalls := 0
+	for _, m := range f.requests {
+		if m == "system.multicall" {
+			multicalls++
+		}
+	}
+	if multicalls != 3 {
+		t.Errorf("requests = %v; want 3 multicalls", f.requests)
+	}
+}
@@ -1,5 +1,14 @@
 package downloader
 
+import "path/filepath"
+
+// task status buckets shared by downloader backends
+const (
+	statusRunning = iota
+	statusCompleted
+	statusError
+)
+
 type DownloadResult struct {
 	Added          uint32
 	Failed         uint32
@@ -7,6 +16,58 @@ type DownloadResult struct {
 	Completed      []string
 	CompletedFiles []string
 	Removed        []string
+	// info hashes of the jobs added in this round
+	AddedJobs []string `json:",omitempty"`
+	// completed file paths relative to the download directory of the work,
+	// grouped by info hash
+	CompletedFileMap map[string][]string `json:",omitempty"`
+	// error messages of the jobs failed in this round by info hash
+	FailedJobs map[string]string `json:",omitempty"`
+}
+
+func (r *DownloadResult) addJob(infoHash string) {
+	r.Added++
+	r.AddedJobs = append(r.AddedJobs, infoHash)
+}
+
+func (r *DownloadResult) fail(infoHash string, err string) {
+	r.Failed++
+	if r.FailedJobs == nil {
+		r.FailedJobs = make(map[string]string)
+	}
+	r.FailedJobs[infoHash] = err
+}
+
+// complete records a completed job with its file paths relative to the
+// download directory, CompletedFiles keeps only the file names as passed to
+// the on complete script.
+func (r *DownloadResult) complete(infoHash string, files []string) {
+	r.Completed = append(r.Completed, infoHash)
+	for _, f := range files {
+		r.CompletedFiles = append(r.CompletedFiles, filepath.Base(f))
+	}
+	if r.CompletedFileMap == nil {
+		r.CompletedFileMap = make(map[string][]string)
+	}
+	r.CompletedFileMap[infoHash] = files
+}
+
+// Progress is the live state of a download task.
+type Progress struct {
+	InfoHash  string `json:"info_hash"`
+	Status    string `json:"status"`
+	Completed int64  `json:"completed"`
+	Total     int64  `json:"total"`
+	// download speed in bytes per second
+	Speed int64 `json:"speed"`
+	// estimated seconds left, 0 if unknown
+	ETA int64 `json:"eta,omitempty"`
+}
+
+func (p *Progress) estimate() {
+	if p.Speed > 0 && p.Total > p.Completed {
+		p.ETA = (p.Total - p.Completed) / p.Speed
+	}
 }
 
 func (r DownloadResult) HasUpdate() bool {
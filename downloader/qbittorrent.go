@@ -0,0 +1,271 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+var errQBForbidden = errors.New("qbittorrent: forbidden")
+
+type QBittorrentDownloader struct {
+	URL      string
+	Username string
+	Password string
+	Dir      string
+
+	client *http.Client
+}
+
+type QBTorrent struct {
+	Hash        string  `json:"hash"`
+	Name        string  `json:"name"`
+	State       string  `json:"state"`
+	Progress    float64 `json:"progress"`
+	Size        int64   `json:"size"`
+	Downloaded  int64   `json:"downloaded"`
+	DLSpeed     int64   `json:"dlspeed"`
+	SavePath    string  `json:"save_path"`
+	ContentPath string  `json:"content_path"`
+}
+
+type QBFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func (d *QBittorrentDownloader) BatchDownload(ctx context.Context, works []*poller.Work) ([]DownloadResult, error) {
+	torrents, err := d.torrentsInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	torrentMap := make(map[string]*QBTorrent)
+	for i := range torrents {
+		torrentMap[strings.ToLower(torrents[i].Hash)] = &torrents[i]
+	}
+	results := make([]DownloadResult, len(works))
+	for i, work := range works {
+		savePath := path.Join(d.Dir, work.Name)
+		var r DownloadResult
+		for _, job := range work.Jobs {
+			t, ok := torrentMap[strings.ToLower(job.InfoHash)]
+			if !ok {
+				if err := d.add(ctx, savePath, job); err != nil {
+					log.Printf("qbittorrent: add %s %s@%s failed: %v\n", job.Type, job.InfoHash, work.Name, err)
+					r.fail(job.InfoHash, err.Error())
+				} else {
+					log.Printf("qbittorrent: add %s %s@%s\n", job.Type, job.InfoHash, work.Name)
+					r.addJob(job.InfoHash)
+				}
+				continue
+			}
+			switch qbStatus(t) {
+			case statusCompleted:
+				files, err := d.files(ctx, t.Hash)
+				if err != nil {
+					log.Printf("qbittorrent: list files error: %s, infoHash: %s\n", err, t.Hash)
+					continue
+				}
+				// remove task from qbittorrent, downloaded files are kept
+				if err := d.delete(ctx, []string{t.Hash}, false); err != nil {
+					log.Printf("qbittorrent: delete torrent error: %s, infoHash: %s\n", err, t.Hash)
+					continue
+				}
+				paths := make([]string, 0, len(files))
+				for _, f := range files {
+					paths = append(paths, f.Name)
+				}
+				r.complete(job.InfoHash, paths)
+			case statusError:
+				log.Printf("qbittorrent: torrent %s@%s in state %s\n", job.InfoHash, work.Name, t.State)
+				r.fail(job.InfoHash, "torrent in state "+t.State)
+			default:
+				r.Running++
+			}
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+func qbStatus(t *QBTorrent) int {
+	switch t.State {
+	case "error", "missingFiles":
+		return statusError
+	case "uploading", "pausedUP", "stoppedUP", "queuedUP", "stalledUP", "forcedUP":
+		return statusCompleted
+	}
+	return statusRunning
+}
+
+func (d *QBittorrentDownloader) torrentsInfo(ctx context.Context) ([]QBTorrent, error) {
+	var torrents []QBTorrent
+	err := d.call(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", d.URL+"/api/v2/torrents/info", nil)
+	}, &torrents)
+	return torrents, err
+}
+
+func (d *QBittorrentDownloader) files(ctx context.Context, hash string) ([]QBFile, error) {
+	var files []QBFile
+	err := d.call(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", d.URL+"/api/v2/torrents/files?hash="+url.QueryEscape(hash), nil)
+	}, &files)
+	return files, err
+}
+
+// Remove deletes the torrents of the given info hashes along with their data.
+func (d *QBittorrentDownloader) Remove(ctx context.Context, infoHashes []string) error {
+	return d.delete(ctx, infoHashes, true)
+}
+
+func (d *QBittorrentDownloader) delete(ctx context.Context, hashes []string, deleteFiles bool) error {
+	form := url.Values{}
+	form.Set("hashes", strings.Join(hashes, "|"))
+	form.Set("deleteFiles", strconv.FormatBool(deleteFiles))
+	return d.call(ctx, func() (*http.Request, error) {
+		return newFormRequest(ctx, d.URL+"/api/v2/torrents/delete", form)
+	}, nil)
+}
+
+func (d *QBittorrentDownloader) add(ctx context.Context, savePath string, job *poller.Job) error {
+	var torrentData []byte
+	switch job.Type {
+	case "torrent":
+		b, err := base64.StdEncoding.DecodeString(job.Content)
+		if err != nil {
+			return err
+		}
+		torrentData = b
+	case "magnet":
+	default:
+		return errors.New("unsupported job type: " + job.Type)
+	}
+	return d.call(ctx, func() (*http.Request, error) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		if torrentData != nil {
+			fw, err := mw.CreateFormFile("torrents", job.InfoHash+".torrent")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := fw.Write(torrentData); err != nil {
+				return nil, err
+			}
+		} else {
+			mw.WriteField("urls", job.Content)
+		}
+		if savePath != "" {
+			mw.WriteField("savepath", savePath)
+		}
+		if err := mw.Close(); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", d.URL+"/api/v2/torrents/add", body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req, nil
+	}, nil)
+}
+
+func (d *QBittorrentDownloader) login(ctx context.Context) error {
+	form := url.Values{}
+	form.Set("username", d.Username)
+	form.Set("password", d.Password)
+	req, err := newFormRequest(ctx, d.URL+"/api/v2/auth/login", form)
+	if err != nil {
+		return err
+	}
+	b, err := d.do(req)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(b)) != "Ok." {
+		return errors.New("qbittorrent: login failed: " + string(b))
+	}
+	return nil
+}
+
+// call sends the request built by newReq, logs in and retries once when the
+// session is missing or expired, and decodes the JSON result into out if
+// it is not nil.
+func (d *QBittorrentDownloader) call(ctx context.Context, newReq func() (*http.Request, error), out interface{}) error {
+	if d.client == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		d.client = &http.Client{Jar: jar}
+		if err := d.login(ctx); err != nil {
+			return err
+		}
+	}
+	req, err := newReq()
+	if err != nil {
+		return err
+	}
+	b, err := d.do(req)
+	if err == errQBForbidden {
+		if err := d.login(ctx); err != nil {
+			return err
+		}
+		if req, err = newReq(); err != nil {
+			return err
+		}
+		b, err = d.do(req)
+	}
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		if s := strings.TrimSpace(string(b)); s == "Fails." {
+			return errors.New("qbittorrent: request " + req.URL.Path + " failed")
+		}
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (d *QBittorrentDownloader) do(req *http.Request) ([]byte, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, errQBForbidden
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err == nil {
+			return nil, errors.New("bad status code: " + resp.Status + ", result: " + string(b))
+		} else {
+			return nil, errors.New("bad status code: " + resp.Status)
+		}
+	}
+	return b, err
+}
+
+func newFormRequest(ctx context.Context, rawURL string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", rawURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
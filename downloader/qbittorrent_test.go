@@ -0,0 +1,183 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+type fakeQBittorrent struct {
+	mu       sync.Mutex
+	torrents []QBTorrent
+	files    map[string][]QBFile
+	added    map[string]string
+	deleted  []string
+	logins   int
+}
+
+func (f *fakeQBittorrent) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.FormValue("username") != "admin" || r.FormValue("password") != "secret" {
+			w.Write([]byte("Fails."))
+			return
+		}
+		f.logins++
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: "session", Path: "/"})
+		w.Write([]byte("Ok."))
+	})
+	auth := func(fn http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if c, err := r.Cookie("SID"); err != nil || c.Value != "session" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			fn(w, r)
+		}
+	}
+	mux.HandleFunc("/api/v2/torrents/info", auth(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(f.torrents)
+	}))
+	mux.HandleFunc("/api/v2/torrents/files", auth(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(f.files[r.FormValue("hash")])
+	}))
+	mux.HandleFunc("/api/v2/torrents/add", auth(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		savePath := r.FormValue("savepath")
+		if urls := r.FormValue("urls"); urls != "" {
+			f.added[urls] = savePath
+		}
+		for _, fh := range r.MultipartForm.File["torrents"] {
+			file, _ := fh.Open()
+			b, _ := io.ReadAll(file)
+			file.Close()
+			f.added[string(b)] = savePath
+		}
+		w.Write([]byte("Ok."))
+	}))
+	mux.HandleFunc("/api/v2/torrents/delete", auth(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("deleteFiles") != "false" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.deleted = append(f.deleted, r.FormValue("hashes"))
+	}))
+	return mux
+}
+
+func TestQBittorrentBatchDownload(t *testing.T) {
+	fake := &fakeQBittorrent{
+		torrents: []QBTorrent{
+			{Hash: "aaaa", State: "downloading"},
+			{Hash: "bbbb", State: "stalledUP", Progress: 1},
+			{Hash: "cccc", State: "error"},
+		},
+		files: map[string][]QBFile{
+			"bbbb": {{Name: "Show/episode 02.mkv"}},
+		},
+		added: map[string]string{},
+	}
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	d := &QBittorrentDownloader{
+		URL:      server.URL,
+		Username: "admin",
+		Password: "secret",
+		Dir:      "/downloads",
+	}
+	works := []*poller.Work{
+		{
+			Name: "Show",
+			Jobs: []*poller.Job{
+				{Type: "torrent", InfoHash: "aaaa"},
+				{Type: "torrent", InfoHash: "BBBB"},
+				{Type: "torrent", InfoHash: "cccc"},
+				{Type: "torrent", InfoHash: "dddd", Content: base64.StdEncoding.EncodeToString([]byte("torrent data"))},
+			},
+		},
+		{
+			Name: "Other",
+			Jobs: []*poller.Job{
+				{Type: "magnet", InfoHash: "eeee", Content: "magnet:?xt=urn:btih:eeee"},
+			},
+		},
+	}
+	results, err := d.BatchDownload(context.Background(), works)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("len(results) = %d; want 2", len(results))
+	}
+	r := results[0]
+	if r.Added != 1 || r.Running != 1 || r.Failed != 1 {
+		t.Errorf("results[0] = %+v; want 1 added, 1 running, 1 failed", r)
+	}
+	if len(r.Completed) != 1 || r.Completed[0] != "BBBB" {
+		t.Errorf("completed = %v; want [BBBB]", r.Completed)
+	}
+	if len(r.CompletedFiles) != 1 || r.CompletedFiles[0] != "episode 02.mkv" {
+		t.Errorf("completed files = %v; want [episode 02.mkv]", r.CompletedFiles)
+	}
+	if files := r.CompletedFileMap["BBBB"]; len(files) != 1 || files[0] != "Show/episode 02.mkv" {
+		t.Errorf("completed file map = %v; want paths relative to the download dir", r.CompletedFileMap)
+	}
+	if results[1].Added != 1 {
+		t.Errorf("results[1] = %+v; want 1 added", results[1])
+	}
+	if p := fake.added["torrent data"]; p != "/downloads/Show" {
+		t.Errorf("torrent save path = %q; want /downloads/Show", p)
+	}
+	if p := fake.added["magnet:?xt=urn:btih:eeee"]; p != "/downloads/Other" {
+		t.Errorf("magnet save path = %q; want /downloads/Other", p)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "bbbb" {
+		t.Errorf("deleted = %v; want [bbbb]", fake.deleted)
+	}
+}
+
+func TestQBittorrentRelogin(t *testing.T) {
+	fake := &fakeQBittorrent{added: map[string]string{}}
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	d := &QBittorrentDownloader{URL: server.URL, Username: "admin", Password: "secret"}
+	if _, err := d.BatchDownload(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// drop the session cookie to simulate an expired session
+	d.client.Jar, _ = cookiejar.New(nil)
+	if _, err := d.BatchDownload(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if fake.logins != 2 {
+		t.Errorf("logins = %d; want 2", fake.logins)
+	}
+}
+
+func TestQBittorrentLoginFailed(t *testing.T) {
+	fake := &fakeQBittorrent{added: map[string]string{}}
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	d := &QBittorrentDownloader{URL: server.URL, Username: "admin", Password: "wrong"}
+	if _, err := d.BatchDownload(context.Background(), nil); err == nil {
+		t.Error("BatchDownload with wrong password succeeded; want error")
+	}
+}
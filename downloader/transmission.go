@@ -0,0 +1,243 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+const transmissionSessionHeader = "X-Transmission-Session-Id"
+
+type TransmissionDownloader struct {
+	URL      string
+	Username string
+	Password string
+	Dir      string
+
+	sessionID string
+}
+
+type TransmissionRequest struct {
+	Method    string      `json:"method"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type TransmissionResponse struct {
+	Result    string          `json:"result"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type TransmissionTorrent struct {
+	ID            int64              `json:"id"`
+	HashString    string             `json:"hashString"`
+	Name          string             `json:"name"`
+	Status        int                `json:"status"`
+	Error         int                `json:"error"`
+	ErrorString   string             `json:"errorString"`
+	PercentDone   float64            `json:"percentDone"`
+	LeftUntilDone int64              `json:"leftUntilDone"`
+	TotalSize     int64              `json:"totalSize"`
+	RateDownload  int64              `json:"rateDownload"`
+	ETA           int64              `json:"eta"`
+	DownloadDir   string             `json:"downloadDir"`
+	Files         []TransmissionFile `json:"files"`
+}
+
+type TransmissionFile struct {
+	Name           string `json:"name"`
+	Length         int64  `json:"length"`
+	BytesCompleted int64  `json:"bytesCompleted"`
+}
+
+// transmission torrent status codes
+const (
+	trStatusStopped = iota
+	trStatusCheckWait
+	trStatusCheck
+	trStatusDownloadWait
+	trStatusDownload
+	trStatusSeedWait
+	trStatusSeed
+)
+
+// transmission torrent error codes, tracker warnings and errors do not stop
+// the download
+const (
+	trErrorNone = iota
+	trErrorTrackerWarning
+	trErrorTrackerError
+	trErrorLocalError
+)
+
+func (d *TransmissionDownloader) BatchDownload(ctx context.Context, works []*poller.Work) ([]DownloadResult, error) {
+	torrents, err := d.torrentGet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	torrentMap := make(map[string]*TransmissionTorrent)
+	for i := range torrents {
+		torrentMap[strings.ToLower(torrents[i].HashString)] = &torrents[i]
+	}
+	results := make([]DownloadResult, len(works))
+	for i, work := range works {
+		downloadDir := path.Join(d.Dir, work.Name)
+		var r DownloadResult
+		for _, job := range work.Jobs {
+			t, ok := torrentMap[strings.ToLower(job.InfoHash)]
+			if !ok {
+				if err := d.torrentAdd(ctx, downloadDir, job); err != nil {
+					log.Printf("transmission: add %s %s@%s failed: %v\n", job.Type, job.InfoHash, work.Name, err)
+					r.fail(job.InfoHash, err.Error())
+				} else {
+					log.Printf("transmission: add %s %s@%s\n", job.Type, job.InfoHash, work.Name)
+					r.addJob(job.InfoHash)
+				}
+				continue
+			}
+			switch trStatus(t) {
+			case statusCompleted:
+				// remove task from transmission, downloaded files are kept
+				if err := d.torrentRemove(ctx, []int64{t.ID}, false); err != nil {
+					log.Printf("transmission: remove torrent error: %s, infoHash: %s\n", err, t.HashString)
+					continue
+				}
+				paths := make([]string, 0, len(t.Files))
+				for _, f := range t.Files {
+					paths = append(paths, f.Name)
+				}
+				r.complete(job.InfoHash, paths)
+			case statusError:
+				log.Printf("transmission: torrent %s@%s error: %s\n", job.InfoHash, work.Name, t.ErrorString)
+				r.fail(job.InfoHash, t.ErrorString)
+			default:
+				r.Running++
+			}
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+func trStatus(t *TransmissionTorrent) int {
+	if t.Error == trErrorLocalError {
+		return statusError
+	}
+	done := t.PercentDone >= 1 && t.LeftUntilDone == 0
+	switch t.Status {
+	case trStatusSeedWait, trStatusSeed:
+		return statusCompleted
+	case trStatusStopped:
+		// a finished torrent is stopped when its seed ratio is reached,
+		// an unfinished one is paused and treated as running like aria2 does
+		if done {
+			return statusCompleted
+		}
+	}
+	return statusRunning
+}
+
+func (d *TransmissionDownloader) torrentGet(ctx context.Context) ([]TransmissionTorrent, error) {
+	args := map[string]interface{}{
+		"fields": []string{"id", "hashString", "name", "status", "error", "errorString", "percentDone", "leftUntilDone", "totalSize", "rateDownload", "eta", "downloadDir", "files"},
+	}
+	var result struct {
+		Torrents []TransmissionTorrent `json:"torrents"`
+	}
+	if err := d.rpcCall(ctx, "torrent-get", args, &result); err != nil {
+		return nil, err
+	}
+	return result.Torrents, nil
+}
+
+func (d *TransmissionDownloader) torrentAdd(ctx context.Context, downloadDir string, job *poller.Job) error {
+	args := map[string]interface{}{}
+	switch job.Type {
+	case "torrent":
+		// job content is already base64 encoded as transmission expects
+		args["metainfo"] = job.Content
+	case "magnet":
+		args["filename"] = job.Content
+	default:
+		return errors.New("unsupported job type: " + job.Type)
+	}
+	if downloadDir != "" {
+		args["download-dir"] = downloadDir
+	}
+	return d.rpcCall(ctx, "torrent-add", args, nil)
+}
+
+// Remove deletes the torrents of the given info hashes along with their data.
+func (d *TransmissionDownloader) Remove(ctx context.Context, infoHashes []string) error {
+	return d.torrentRemove(ctx, infoHashes, true)
+}
+
+// torrentRemove removes torrents by ids, which are either ids or hash strings.
+func (d *TransmissionDownloader) torrentRemove(ctx context.Context, ids interface{}, deleteData bool) error {
+	args := map[string]interface{}{
+		"ids":               ids,
+		"delete-local-data": deleteData,
+	}
+	return d.rpcCall(ctx, "torrent-remove", args, nil)
+}
+
+func (d *TransmissionDownloader) rpcCall(ctx context.Context, method string, args interface{}, out interface{}) error {
+	body, err := json.Marshal(&TransmissionRequest{Method: method, Arguments: args})
+	if err != nil {
+		return err
+	}
+	resp, err := d.post(ctx, body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		// session id missing or expired, retry with the one returned by server
+		resp.Body.Close()
+		d.sessionID = resp.Header.Get(transmissionSessionHeader)
+		if resp, err = d.post(ctx, body); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(resp.Body)
+		if err == nil {
+			return errors.New("bad status code: " + resp.Status + ", result: " + string(b))
+		} else {
+			return errors.New("bad status code: " + resp.Status)
+		}
+	}
+	var r TransmissionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return err
+	}
+	if r.Result != "success" {
+		return errors.New("transmission: " + r.Result)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Arguments, out)
+}
+
+func (d *TransmissionDownloader) post(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", d.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.sessionID != "" {
+		req.Header.Set(transmissionSessionHeader, d.sessionID)
+	}
+	if d.Username != "" || d.Password != "" {
+		req.SetBasicAuth(d.Username, d.Password)
+	}
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+type fakeTransmission struct {
+	mu       sync.Mutex
+	torrents []TransmissionTorrent
+	added    []map[string]interface{}
+	removed  []int64
+	conflict int
+}
+
+func (f *fakeTransmission) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get(transmissionSessionHeader) != "session" {
+		f.conflict++
+		w.Header().Set(transmissionSessionHeader, "session")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	var req struct {
+		Method    string                 `json:"method"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp := map[string]interface{}{"result": "success"}
+	switch req.Method {
+	case "torrent-get":
+		resp["arguments"] = map[string]interface{}{"torrents": f.torrents}
+	case "torrent-add":
+		f.added = append(f.added, req.Arguments)
+		resp["arguments"] = map[string]interface{}{"torrent-added": map[string]interface{}{}}
+	case "torrent-remove":
+		if req.Arguments["delete-local-data"] != false {
+			resp["result"] = "local data must be kept"
+			break
+		}
+		for _, id := range req.Arguments["ids"].([]interface{}) {
+			f.removed = append(f.removed, int64(id.(float64)))
+		}
+	default:
+		resp["result"] = "method name not recognized"
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestTransmissionBatchDownload(t *testing.T) {
+	fake := &fakeTransmission{
+		torrents: []TransmissionTorrent{
+			{ID: 1, HashString: "aaaa", Status: trStatusDownload, PercentDone: 0.5, LeftUntilDone: 100},
+			{ID: 2, HashString: "bbbb", Status: trStatusSeed, PercentDone: 1, Files: []TransmissionFile{{Name: "Show/episode 02.mkv"}}},
+			{ID: 3, HashString: "cccc", Status: trStatusStopped, PercentDone: 1},
+			{ID: 4, HashString: "dddd", Status: trStatusStopped, Error: 3, ErrorString: "No data found"},
+			{ID: 5, HashString: "ffff", Status: trStatusStopped, PercentDone: 0.2, LeftUntilDone: 100},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	d := &TransmissionDownloader{URL: server.URL, Dir: "/downloads"}
+	works := []*poller.Work{
+		{
+			Name: "Show",
+			Jobs: []*poller.Job{
+				{Type: "torrent", InfoHash: "aaaa"},
+				{Type: "torrent", InfoHash: "bbbb"},
+				{Type: "torrent", InfoHash: "cccc"},
+				{Type: "torrent", InfoHash: "dddd"},
+				{Type: "torrent", InfoHash: "ffff"},
+				{Type: "torrent", InfoHash: "eeee", Content: "dG9ycmVudA=="},
+				{Type: "magnet", InfoHash: "1111", Content: "magnet:?xt=urn:btih:1111"},
+			},
+		},
+	}
+	results, err := d.BatchDownload(context.Background(), works)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if r.Added != 2 || r.Running != 2 || r.Failed != 1 {
+		t.Errorf("result = %+v; want 2 added, 2 running, 1 failed", r)
+	}
+	if len(r.Completed) != 2 || r.Completed[0] != "bbbb" || r.Completed[1] != "cccc" {
+		t.Errorf("completed = %v; want [bbbb cccc]", r.Completed)
+	}
+	if len(r.CompletedFiles) != 1 || r.CompletedFiles[0] != "episode 02.mkv" {
+		t.Errorf("completed files = %v; want [episode 02.mkv]", r.CompletedFiles)
+	}
+	if files := r.CompletedFileMap["bbbb"]; len(files) != 1 || files[0] != "Show/episode 02.mkv" {
+		t.Errorf("completed file map = %v; want paths relative to the download dir", r.CompletedFileMap)
+	}
+	if len(fake.removed) != 2 || fake.removed[0] != 2 || fake.removed[1] != 3 {
+		t.Errorf("removed = %v; want [2 3]", fake.removed)
+	}
+	if len(fake.added) != 2 {
+		t.Fatalf("added = %v; want 2 torrents", fake.added)
+	}
+	if fake.added[0]["metainfo"] != "dG9ycmVudA==" || fake.added[0]["download-dir"] != "/downloads/Show" {
+		t.Errorf("added[0] = %v; want metainfo in /downloads/Show", fake.added[0])
+	}
+	if fake.added[1]["filename"] != "magnet:?xt=urn:btih:1111" {
+		t.Errorf("added[1] = %v; want magnet filename", fake.added[1])
+	}
+	if fake.conflict != 1 {
+		t.Errorf("session handshakes = %d; want 1", fake.conflict)
+	}
+}
+
+func TestTransmissionErrorStatus(t *testing.T) {
+	cases := []struct {
+		err  int
+		want int
+	}{
+		{trErrorNone, statusRunning},
+		{trErrorTrackerWarning, statusRunning},
+		{trErrorTrackerError, statusRunning},
+		{trErrorLocalError, statusError},
+	}
+	for _, c := range cases {
+		tr := &TransmissionTorrent{Status: trStatusDownload, PercentDone: 0.5, LeftUntilDone: 100, Error: c.err}
+		if got := trStatus(tr); got != c.want {
+			t.Errorf("trStatus(error %d) = %d; want %d", c.err, got, c.want)
+		}
+	}
+}
@@ -1,21 +1,30 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/lonord/rss-torrent-downloader/downloader"
 	"github.com/lonord/rss-torrent-downloader/flagx"
+	"github.com/lonord/rss-torrent-downloader/poller"
+	"github.com/lonord/rss-torrent-downloader/poller/torrent"
 	"github.com/lonord/rss-torrent-downloader/repo"
 	"github.com/lonord/rss-torrent-downloader/webapi"
 	"github.com/lonord/rss-torrent-downloader/worker"
 )
 
 const (
-	ARIA2_SERVER = "http://127.0.0.1:6800"
+	ARIA2_SERVER        = "http://127.0.0.1:6800"
+	QBITTORRENT_SERVER  = "http://127.0.0.1:8080"
+	TRANSMISSION_SERVER = "http://127.0.0.1:9091/transmission/rpc"
 )
 
 var (
@@ -27,22 +36,80 @@ var (
 var flags struct {
 	version      bool
 	subscription string
+	db           string
+	importSub    bool
 	dir          string
+	downloader   string
 	aria2        string
 	secret       string
+	aria2WS      bool
+	qbittorrent  string
+	qbUsername   string
+	qbPassword   string
+	transmission string
+	trUsername   string
+	trPassword   string
 	interval     int
+	parallelism  int
+	perHost      int
+	retryMax     int
+	retryBackoff time.Duration
 	httpAddr     string
 	onComplete   string
+	torrentCache string
+	proxy        string
+	headers      string
+	userAgent    string
+	cookieFile   string
+	cookieDir    string
+	fetchTimeout time.Duration
+	insecure     bool
+	apiKeys      string
+	basicAuth    string
+	tls          bool
+	tlsCert      string
+	tlsKey       string
+	tlsDir       string
+	tlsClientCA  string
 }
 
 func init() {
 	flag.BoolVar(&flags.version, "version", false, "show version")
 	flag.StringVar(&flags.subscription, "subscription", "subscription", "`directory` for reading subscription files")
-	flag.StringVar(&flags.dir, "dir", "", "aria2 download directory, empty for server default")
+	flag.StringVar(&flags.db, "db", "", "`path` to sqlite database for storing subscriptions and download history, empty for subscription files")
+	flag.BoolVar(&flags.importSub, "import-subscription", false, "import subscription files into the sqlite database on start")
+	flag.StringVar(&flags.dir, "dir", "", "download directory, empty for server default")
+	flag.StringVar(&flags.downloader, "downloader", "aria2", "downloader backend, `aria2|qbittorrent|transmission`")
 	flag.StringVar(&flags.aria2, "aria2", ARIA2_SERVER, "`addr` for connecting video downloader server")
 	flag.StringVar(&flags.secret, "secret", "", "aria2 secret token")
+	flag.BoolVar(&flags.aria2WS, "aria2-websocket", false, "listen to aria2 notifications over websocket to record completed downloads at once")
+	flag.StringVar(&flags.qbittorrent, "qbittorrent", QBITTORRENT_SERVER, "`addr` for connecting qbittorrent web api")
+	flag.StringVar(&flags.qbUsername, "qbittorrent-username", "", "qbittorrent web api username")
+	flag.StringVar(&flags.qbPassword, "qbittorrent-password", "", "qbittorrent web api password")
+	flag.StringVar(&flags.transmission, "transmission", TRANSMISSION_SERVER, "`url` for connecting transmission rpc")
+	flag.StringVar(&flags.trUsername, "transmission-username", "", "transmission rpc username")
+	flag.StringVar(&flags.trPassword, "transmission-password", "", "transmission rpc password")
 	flag.IntVar(&flags.interval, "interval", 60, "interval of `minutes` to poll")
+	flag.IntVar(&flags.parallelism, "poll-parallelism", 4, "max `number` of feeds fetched at the same time")
+	flag.IntVar(&flags.perHost, "poll-per-host", 2, "max `number` of feeds fetched at the same time from one host")
+	flag.IntVar(&flags.retryMax, "retry-max", 5, "max `number` of attempts of a failed download before giving up")
+	flag.DurationVar(&flags.retryBackoff, "retry-backoff", time.Minute*10, "`duration` before retrying a failed download, doubled after each attempt")
 	flag.StringVar(&flags.httpAddr, "http", ":6900", "`addr` for http server")
+	flag.BoolVar(&flags.tls, "http-tls", false, "serve https, with a self-signed certificate if -http-tls-cert and -http-tls-key are not set")
+	flag.StringVar(&flags.tlsCert, "http-tls-cert", "", "`path` to tls certificate for https, implies -http-tls")
+	flag.StringVar(&flags.tlsKey, "http-tls-key", "", "`path` to tls key for https, implies -http-tls")
+	flag.StringVar(&flags.tlsDir, "http-tls-dir", "tls", "`directory` where the self-signed certificate is kept")
+	flag.StringVar(&flags.tlsClientCA, "http-tls-client-ca", "", "`path` to ca certificates, clients must present a certificate signed by them")
+	flag.StringVar(&flags.apiKeys, "api-keys", "", "comma separated `key:scope` pairs accepted by the http server, scope is read or admin")
+	flag.StringVar(&flags.basicAuth, "basic-auth", "", "comma separated `user:hash:scope` entries accepted by the http server, hash from the hash-password command")
+	flag.StringVar(&flags.torrentCache, "torrent-cache", "", "`directory` for caching fetched .torrent files, empty to disable")
+	flag.StringVar(&flags.proxy, "proxy", "", "http, https or socks5 proxy `url` for fetching feeds and torrents")
+	flag.StringVar(&flags.headers, "headers", "", "extra request `headers` for fetching feeds and torrents, one \"Name: value\" per line")
+	flag.StringVar(&flags.userAgent, "user-agent", "", "User-Agent for fetching feeds and torrents")
+	flag.StringVar(&flags.cookieFile, "cookie-file", "", "`path` to cookies file in the Netscape format for fetching feeds and torrents")
+	flag.StringVar(&flags.cookieDir, "cookie-dir", "", "`directory` of cookies files subscriptions can select with the cookie_file option")
+	flag.DurationVar(&flags.fetchTimeout, "fetch-timeout", 0, "`timeout` of fetching a feed or torrent, 0 for no limit")
+	flag.BoolVar(&flags.insecure, "insecure-skip-verify", false, "skip TLS certificate verification when fetching feeds and torrents")
 	flag.StringVar(&flags.onComplete, "on-complete-script", "", "`path` to script or executable invoked with completed file paths")
 }
 
@@ -52,21 +119,184 @@ func main() {
 		fmt.Printf("%s version %s build on %s %s/%s\n", appName, appVersion, buildTime, runtime.GOOS, runtime.GOARCH)
 		os.Exit(0)
 	}
+	if err := torrent.SetCacheDir(flags.torrentCache); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := setClientConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if flag.Arg(0) == "hash-password" {
+		if err := runHashPassword(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if flag.Arg(0) == "preview" {
+		if err := runPreview(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
+	down, err := newDownloader()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	subRepo, err := newRepo()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	w := &worker.Worker{
-		Repo:             &repo.FileRepo{Dir: flags.subscription},
-		Interval:         time.Minute * time.Duration(flags.interval),
-		OnCompleteScript: flags.onComplete,
-		Down: &downloader.Aria2Downloader{
-			URL:    flags.aria2,
-			Secret: flags.secret,
-			Dir:    flags.dir,
-		},
+		Repo:               subRepo,
+		Interval:           time.Minute * time.Duration(flags.interval),
+		OnCompleteScript:   flags.onComplete,
+		Down:               down,
+		Parallelism:        flags.parallelism,
+		PerHostParallelism: flags.perHost,
+		RetryMax:           flags.retryMax,
+		RetryBackoff:       flags.retryBackoff,
+	}
+	auth, err := newAuth()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 	httpServer := &webapi.HTTPServer{
 		Addr:   flags.httpAddr,
 		Worker: w,
+		Auth:   auth,
 	}
-	go httpServer.Run()
+	if flags.tls || flags.tlsCert != "" || flags.tlsKey != "" {
+		httpServer.TLS = &webapi.TLSConfig{
+			CertFile:      flags.tlsCert,
+			KeyFile:       flags.tlsKey,
+			SelfSignedDir: flags.tlsDir,
+			ClientCAFile:  flags.tlsClientCA,
+		}
+	} else if flags.tlsClientCA != "" {
+		fmt.Fprintln(os.Stderr, "-http-tls-client-ca requires -http-tls")
+		os.Exit(1)
+	}
+	go func() {
+		if err := httpServer.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}()
 	w.Run()
 }
+
+// newRepo opens the subscription repo, importing the subscription directory
+// into the database if -import-subscription is set.
+func newRepo() (worker.SubscriptionRepo, error) {
+	fileRepo := &repo.FileRepo{Dir: flags.subscription}
+	if flags.db == "" {
+		return fileRepo, nil
+	}
+	sqliteRepo, err := repo.NewSQLiteRepo(flags.db)
+	if err != nil {
+		return nil, err
+	}
+	if flags.importSub {
+		n, err := sqliteRepo.Import(fileRepo)
+		log.Printf("imported %d subscriptions from %s\n", n, flags.subscription)
+		if err != nil {
+			sqliteRepo.Close()
+			return nil, errors.New("import subscriptions error: " + err.Error())
+		}
+	}
+	return sqliteRepo, nil
+}
+
+// newReadOnlyRepo opens the subscription repo without changing it, the
+// database is neither created nor migrated.
+func newReadOnlyRepo() (worker.SubscriptionRepo, error) {
+	if flags.db == "" {
+		return &repo.FileRepo{Dir: flags.subscription}, nil
+	}
+	return repo.OpenSQLiteRepoReadOnly(flags.db)
+}
+
+func newAuth() (*webapi.Auth, error) {
+	keys, err := webapi.ParseKeys(flags.apiKeys)
+	if err != nil {
+		return nil, err
+	}
+	users, err := webapi.ParseUsers(flags.basicAuth)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 && len(users) == 0 {
+		log.Println("web api authentication is disabled")
+	}
+	return &webapi.Auth{Keys: keys, Users: users}, nil
+}
+
+// runHashPassword prints the bcrypt hash of the password read from stdin,
+// for use in -basic-auth.
+func runHashPassword() error {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("empty password")
+	}
+	hash, err := webapi.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+func setClientConfig() error {
+	headers, err := poller.ParseHeaders(flags.headers)
+	if err != nil {
+		return err
+	}
+	return poller.SetDefaultClientConfig(poller.ClientConfig{
+		Proxy:              flags.proxy,
+		Headers:            headers,
+		UserAgent:          flags.userAgent,
+		CookieFile:         flags.cookieFile,
+		CookieDir:          flags.cookieDir,
+		Timeout:            flags.fetchTimeout,
+		InsecureSkipVerify: flags.insecure,
+	})
+}
+
+func newDownloader() (worker.Downloader, error) {
+	switch flags.downloader {
+	case "aria2":
+		return &downloader.Aria2Downloader{
+			URL:       flags.aria2,
+			Secret:    flags.secret,
+			Dir:       flags.dir,
+			WebSocket: flags.aria2WS,
+		}, nil
+	case "qbittorrent":
+		return &downloader.QBittorrentDownloader{
+			URL:      flags.qbittorrent,
+			Username: flags.qbUsername,
+			Password: flags.qbPassword,
+			Dir:      flags.dir,
+		}, nil
+	case "transmission":
+		return &downloader.TransmissionDownloader{
+			URL:      flags.transmission,
+			Username: flags.trUsername,
+			Password: flags.trPassword,
+			Dir:      flags.dir,
+		}, nil
+	default:
+		return nil, errors.New("unsupported downloader: " + flags.downloader)
+	}
+}
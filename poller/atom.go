@@ -0,0 +1,93 @@
+package poller
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+type AtomFeed struct {
+	XMLName  xml.Name     `xml:"feed"`
+	Title    string       `xml:"title"`
+	Links    []AtomLink   `xml:"link"`
+	Subtitle string       `xml:"subtitle"`
+	Entries  []*AtomEntry `xml:"entry"`
+}
+
+type AtomEntry struct {
+	Title     string     `xml:"title"`
+	Links     []AtomLink `xml:"link"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+type AtomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+// toRSS normalizes an atom feed into the RSS model so that pollers and
+// filters do not need to care about the feed format.
+func (f *AtomFeed) toRSS() *RSS {
+	rss := &RSS{
+		Title: f.Title,
+		Link:  alternateLink(f.Links),
+		Desc:  f.Subtitle,
+		Items: make([]*RSSItem, 0, len(f.Entries)),
+	}
+	for _, e := range f.Entries {
+		item := &RSSItem{
+			Title: e.Title,
+			Link:  alternateLink(e.Links),
+			Desc:  e.Summary,
+		}
+		if item.Desc == "" {
+			item.Desc = e.Content
+		}
+		for _, l := range e.Links {
+			if l.Rel == "enclosure" {
+				item.Enclosure = Enclosure{
+					URL:    l.Href,
+					Length: l.Length,
+					Type:   l.Type,
+				}
+				if l.Length > 0 {
+					item.Entry.ContentLength = uint64(l.Length)
+				}
+				break
+			}
+		}
+		item.Entry.Link = item.Enclosure.URL
+		pubDate := e.Published
+		if pubDate == "" {
+			pubDate = e.Updated
+		}
+		item.Entry.PubDate = formatAtomTime(pubDate)
+		rss.Items = append(rss.Items, item)
+	}
+	return rss
+}
+
+func alternateLink(links []AtomLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// formatAtomTime converts RFC 3339 timestamps to the local layout understood
+// by the time filter, values in other formats are kept as is.
+func formatAtomTime(s string) string {
+	s = strings.TrimSpace(s)
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return s
+	}
+	return t.Local().Format("2006-01-02T15:04:05")
+}
@@ -0,0 +1,76 @@
+package poller
+
+import (
+	"strings"
+	"testing"
+)
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Some Tracker</title>
+  <link href="https://tracker.example/"/>
+  <entry>
+    <title>[Group] Show - 01 [1080p]</title>
+    <link rel="alternate" href="https://tracker.example/view/1"/>
+    <link rel="enclosure" type="application/x-bittorrent" length="1024" href="https://tracker.example/download/1.torrent"/>
+    <updated>2024-05-01T12:30:00Z</updated>
+    <summary>first episode</summary>
+  </entry>
+</feed>`
+
+const rssFeed = `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Some Tracker</title>
+    <item>
+      <title>[Group] Show - 01 [1080p]</title>
+      <enclosure type="application/x-bittorrent" length="1024" url="https://tracker.example/download/1.torrent"/>
+    </item>
+  </channel>
+</rss>`
+
+func TestDecodeFeedAtom(t *testing.T) {
+	rss, err := decodeFeed(strings.NewReader(atomFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rss.Title != "Some Tracker" || rss.Link != "https://tracker.example/" {
+		t.Errorf("feed = %q %q; want Some Tracker https://tracker.example/", rss.Title, rss.Link)
+	}
+	if len(rss.Items) != 1 {
+		t.Fatalf("len(items) = %d; want 1", len(rss.Items))
+	}
+	item := rss.Items[0]
+	if item.Link != "https://tracker.example/view/1" {
+		t.Errorf("link = %q; want https://tracker.example/view/1", item.Link)
+	}
+	if item.Desc != "first episode" {
+		t.Errorf("desc = %q; want first episode", item.Desc)
+	}
+	want := Enclosure{URL: "https://tracker.example/download/1.torrent", Length: 1024, Type: "application/x-bittorrent"}
+	if item.Enclosure != want {
+		t.Errorf("enclosure = %+v; want %+v", item.Enclosure, want)
+	}
+	if item.Entry.ContentLength != 1024 {
+		t.Errorf("contentLength = %d; want 1024", item.Entry.ContentLength)
+	}
+	if _, err := parseTime(item.Entry.PubDate); err != nil {
+		t.Errorf("pubDate %q not parseable: %v", item.Entry.PubDate, err)
+	}
+}
+
+func TestDecodeFeedRSS(t *testing.T) {
+	rss, err := decodeFeed(strings.NewReader(rssFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rss.Items) != 1 || rss.Items[0].Enclosure.Type != "application/x-bittorrent" {
+		t.Errorf("items = %+v; want one torrent item", rss.Items)
+	}
+}
+
+func TestDecodeFeedUnsupported(t *testing.T) {
+	if _, err := decodeFeed(strings.NewReader(`<html></html>`)); err == nil {
+		t.Error("decodeFeed(html) succeeded; want error")
+	}
+}
@@ -0,0 +1,183 @@
+package poller
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Episode is the season and episode range parsed from an item title, a
+// single episode has Start == End.
+type Episode struct {
+	Season int `json:"season"`
+	Start  int `json:"start"`
+	End    int `json:"end"`
+}
+
+// Keys returns the keys of all episodes covered, like "S01E05".
+func (e *Episode) Keys() []string {
+	keys := make([]string, 0, e.End-e.Start+1)
+	for n := e.Start; n <= e.End; n++ {
+		keys = append(keys, fmt.Sprintf("S%02dE%02d", e.Season, n))
+	}
+	return keys
+}
+
+func (e *Episode) String() string {
+	if e.Start == e.End {
+		return fmt.Sprintf("S%02dE%02d", e.Season, e.Start)
+	}
+	return fmt.Sprintf("S%02dE%02d-E%02d", e.Season, e.Start, e.End)
+}
+
+var (
+	seasonEpisodeReg = regexp.MustCompile(`(?i)\bS(\d{1,2})\s?E(\d{1,4})(?:\s?-\s?E?(\d{1,4}))?`)
+	seasonReg        = regexp.MustCompile(`(?i)(?:\bS|\bSeason\s?)(\d{1,2})\b|第\s?(\d{1,2}|[一二两三四五六七八九十]{1,3})\s?季`)
+	// a single digit after the name, like "Oshi no Ko 2 - 05"
+	numberedSeasonReg = regexp.MustCompile(`\w\s([2-9])\s[-–]\s\d{1,4}\b`)
+	// the following patterns capture the first and optionally the last
+	// episode of a batch
+	episodePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`第\s?(\d{1,4})\s?(?:[-~]\s?(\d{1,4})\s?)?[话話集]`),
+		regexp.MustCompile(`(?i)[\[【](\d{1,4})\s?[-~]\s?(\d{1,4})(?:\s?(?:END|Fin))?(?:v\d)?[\]】]`),
+		// outside of brackets "2 - 05" is a season and an episode, not a batch
+		regexp.MustCompile(`(?i)\s(\d{1,4})(?:[-~]\s?|\s[-~])(\d{1,4})(?:\s?(?:END|Fin))?(?:v\d)?[\]】\s]`),
+		regexp.MustCompile(`(?i)\bEP?\.?\s?(\d{1,4})(?:v\d)?()\b`),
+		regexp.MustCompile(`(?i)\s[-–]\s(\d{1,4})(?:v\d)?()(?:\b|$)`),
+		regexp.MustCompile(`(?i)[\[【](\d{1,4})(?:v\d)?()(?:\s?END)?[\]】]`),
+	}
+)
+
+// ParseEpisode extracts the season and episode numbers from a title, the
+// season defaults to 1 when not present.
+func ParseEpisode(title string) (*Episode, bool) {
+	if m := seasonEpisodeReg.FindStringSubmatch(title); m != nil {
+		season, _ := strconv.Atoi(m[1])
+		start, _ := strconv.Atoi(m[2])
+		end := start
+		if m[3] != "" {
+			end, _ = strconv.Atoi(m[3])
+		}
+		if end < start {
+			return nil, false
+		}
+		return &Episode{Season: season, Start: start, End: end}, true
+	}
+	// the season number could be mistaken for an episode, e.g. "Season 2 - 11"
+	stripped := seasonReg.ReplaceAllString(title, " ")
+	for _, reg := range episodePatterns {
+		for _, m := range reg.FindAllStringSubmatch(stripped, -1) {
+			start, _ := strconv.Atoi(m[1])
+			end := start
+			if m[2] != "" {
+				end, _ = strconv.Atoi(m[2])
+			}
+			if !isEpisodeNumber(start) || !isEpisodeNumber(end) || end < start {
+				continue
+			}
+			return &Episode{Season: parseSeason(title), Start: start, End: end}, true
+		}
+	}
+	return nil, false
+}
+
+func parseSeason(title string) int {
+	m := seasonReg.FindStringSubmatch(title)
+	if m == nil {
+		if m := numberedSeasonReg.FindStringSubmatch(title); m != nil {
+			season, _ := strconv.Atoi(m[1])
+			return season
+		}
+		return 1
+	}
+	s := m[1]
+	if s == "" {
+		s = m[2]
+	}
+	season, err := strconv.Atoi(s)
+	if err != nil {
+		season = parseChineseNumber(s)
+	}
+	if season == 0 {
+		return 1
+	}
+	return season
+}
+
+var chineseDigits = map[rune]int{
+	'一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9,
+}
+
+// parseChineseNumber parses Chinese numerals below 100, like "二", "十一" or
+// "二十三", it returns 0 if s is not one.
+func parseChineseNumber(s string) int {
+	tens, units, found := strings.Cut(s, "十")
+	if !found {
+		return chineseDigit(s)
+	}
+	n := 10
+	if tens != "" {
+		n = chineseDigit(tens) * 10
+	}
+	if units != "" {
+		d := chineseDigit(units)
+		if n == 0 || d == 0 {
+			return 0
+		}
+		n += d
+	}
+	return n
+}
+
+func chineseDigit(s string) int {
+	r := []rune(s)
+	if len(r) != 1 {
+		return 0
+	}
+	return chineseDigits[r[0]]
+}
+
+// isEpisodeNumber rules out numbers that are most likely a year or a
+// resolution.
+func isEpisodeNumber(n int) bool {
+	switch n {
+	case 480, 720, 1080, 2160:
+		return false
+	}
+	return !(n >= 1900 && n < 2100)
+}
+
+// KeepNewEpisodes drops jobs whose episodes were all chosen before with
+// another info hash, and keeps only the first job of each episode in this
+// work. Jobs without an episode number are kept.
+func (w *Work) KeepNewEpisodes(chosen map[string]string) {
+	seen := map[string]bool{}
+	jobs := []*Job{}
+	for _, job := range w.Jobs {
+		if job.Episode == nil {
+			jobs = append(jobs, job)
+			continue
+		}
+		keys := job.Episode.Keys()
+		isNew := false
+		isChosen := false
+		for _, key := range keys {
+			infoHash, ok := chosen[key]
+			if ok && infoHash == job.InfoHash {
+				isChosen = true
+			}
+			if !ok && !seen[key] {
+				isNew = true
+			}
+		}
+		if !isChosen && !isNew {
+			continue
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+		jobs = append(jobs, job)
+	}
+	w.Jobs = jobs
+}
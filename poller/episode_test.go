@@ -0,0 +1,75 @@
+package poller
+
+import "testing"
+
+func TestParseEpisode(t *testing.T) {
+	cases := map[string]string{
+		"Show.S02E05.1080p.WEB-DL.x264":       "S02E05",
+		"Show S01E01-E03 1080p":               "S01E01-E03",
+		"[GroupA] Show - 05 [1080p][HEVC]":    "S01E05",
+		"[GroupA] Show - 05v2 (1080p)":        "S01E05",
+		"[GroupA] Show Season 2 - 11 [720p]":  "S02E11",
+		"[GroupA][Show][05][1080P][GB]":       "S01E05",
+		"[GroupA] 某番剧 第05话 1080p":             "S01E05",
+		"[GroupA] 某番剧 第二季 第12集 [2024]":        "S02E12",
+		"[GroupA] 某番剧 第十一季 第03话":              "S11E03",
+		"[GroupA] 某番剧 第二十三季 - 05":             "S23E05",
+		"[GroupA] 某番剧 第2季 第12集":               "S02E12",
+		"[GroupA] Show [01-12][1080p][BDRip]": "S01E01-E12",
+		"[GroupA] Show 01-12 END 1080p":       "S01E01-E12",
+		"[GroupA] Show [01 - 12][1080p]":      "S01E01-E12",
+		"[GroupA] Oshi no Ko 2 - 05 [1080p]":  "S02E05",
+		"Title 2 - 05":                        "S02E05",
+		"[GroupA] Show 第01-13话 合集":            "S01E01-E13",
+		"Show EP07 [1080p]":                   "S01E07",
+		"[GroupA] Show [2024][1080p] - 08":    "S01E08",
+		"[GroupA] Show (2024) [1080p][03]":    "S01E03",
+		"[GroupA] Show 1080p":                 "",
+		"[GroupA] Show Movie [2024][1080p]":   "",
+	}
+	for title, want := range cases {
+		got := ""
+		if ep, ok := ParseEpisode(title); ok {
+			got = ep.String()
+		}
+		if got != want {
+			t.Errorf("ParseEpisode(%q) = %q; want %q", title, got, want)
+		}
+	}
+}
+
+func TestParseChineseNumber(t *testing.T) {
+	cases := map[string]int{"一": 1, "两": 2, "十": 10, "十一": 11, "二十": 20, "二十三": 23, "九十九": 99, "十十": 0, "一二": 0}
+	for s, want := range cases {
+		if got := parseChineseNumber(s); got != want {
+			t.Errorf("parseChineseNumber(%q) = %d; want %d", s, got, want)
+		}
+	}
+}
+
+func TestKeepNewEpisodes(t *testing.T) {
+	w := &Work{Jobs: []*Job{
+		{InfoHash: "a", Episode: &Episode{Season: 1, Start: 5, End: 5}},
+		{InfoHash: "b", Episode: &Episode{Season: 1, Start: 5, End: 5}},
+		{InfoHash: "c", Episode: &Episode{Season: 1, Start: 4, End: 4}},
+		{InfoHash: "d", Episode: &Episode{Season: 1, Start: 3, End: 3}},
+		{InfoHash: "e", Episode: &Episode{Season: 1, Start: 1, End: 4}},
+		{InfoHash: "f", Episode: &Episode{Season: 1, Start: 1, End: 6}},
+		{InfoHash: "g"},
+	}}
+	w.KeepNewEpisodes(map[string]string{
+		"S01E03": "d",
+		"S01E04": "x",
+		"S01E01": "y",
+		"S01E02": "y",
+	})
+	got := ""
+	for _, job := range w.Jobs {
+		got += job.InfoHash
+	}
+	// a is a new episode, b is a second release of it, c was chosen with
+	// another release, d is still tracked, e has no new episode, f has E06
+	if got != "adfg" {
+		t.Errorf("kept jobs = %s; want adfg", got)
+	}
+}
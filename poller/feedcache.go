@@ -0,0 +1,53 @@
+package poller
+
+import (
+	"sync"
+)
+
+// cachedFeed is the last feed fetched from a url with one client
+// configuration, with the validators to send in a conditional request.
+// Feeds fetched with other cookies or headers may differ, so they are cached
+// separately.
+type cachedFeed struct {
+	etag         string
+	lastModified string
+	rss          *RSS
+}
+
+var feedCache = struct {
+	sync.Mutex
+	feeds map[string]*cachedFeed
+}{feeds: make(map[string]*cachedFeed)}
+
+func feedCacheKey(clientKey, url string) string {
+	return clientKey + "\n" + url
+}
+
+func getCachedFeed(clientKey, url string) *cachedFeed {
+	feedCache.Lock()
+	defer feedCache.Unlock()
+	return feedCache.feeds[feedCacheKey(clientKey, url)]
+}
+
+func putCachedFeed(clientKey, url string, feed *cachedFeed) {
+	feedCache.Lock()
+	defer feedCache.Unlock()
+	key := feedCacheKey(clientKey, url)
+	if feed.etag == "" && feed.lastModified == "" {
+		delete(feedCache.feeds, key)
+		return
+	}
+	feedCache.feeds[key] = feed
+}
+
+// copyRSS copies the feed and its items, so that callers cannot change the
+// cached feed.
+func copyRSS(rss *RSS) *RSS {
+	c := *rss
+	c.Items = make([]*RSSItem, len(rss.Items))
+	for i, item := range rss.Items {
+		itemCopy := *item
+		c.Items[i] = &itemCopy
+	}
+	return &c
+}
@@ -0,0 +1,78 @@
+package poller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchRSSConditional(t *testing.T) {
+	const etag = `"v1"`
+	full, notModified := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(`<rss><channel><title>Show</title><item><title>Show - 01</title></item></channel></rss>`))
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		rss, err := fetchRSS(context.Background(), http.DefaultClient, "", srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rss.Items) != 1 || rss.Items[0].Title != "Show - 01" {
+			t.Errorf("fetch %d: items = %+v; want Show - 01", i, rss.Items)
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Errorf("full = %d, not modified = %d; want 1, 1", full, notModified)
+	}
+}
+
+func TestFetchRSSCachePerClient(t *testing.T) {
+	const etag = `"v1"`
+	full := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", etag)
+		// the feed depends on the credentials, the etag does not
+		w.Write([]byte(`<rss><channel><title>Show</title><item><title>` + r.Header.Get("X-User") + `</title></item></channel></rss>`))
+	}))
+	defer srv.Close()
+
+	fetch := func(user string) *RSS {
+		t.Helper()
+		client, key, err := clientOf(map[string]string{"headers": "X-User: " + user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rss, err := fetchRSS(context.Background(), client, key, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rss
+	}
+	if rss := fetch("alice"); rss.Items[0].Title != "alice" {
+		t.Errorf("alice got %q", rss.Items[0].Title)
+	}
+	rss := fetch("bob")
+	if rss.Items[0].Title != "bob" || full != 2 {
+		t.Errorf("bob got %q after %d full fetches; want his own feed", rss.Items[0].Title, full)
+	}
+	// changing a returned feed leaves the cached one intact
+	rss.Items[0].Title = "changed"
+	if rss := fetch("bob"); rss.Items[0].Title != "bob" || full != 2 {
+		t.Errorf("cached feed = %q after %d full fetches; want bob from cache", rss.Items[0].Title, full)
+	}
+}
@@ -0,0 +1,99 @@
+package poller
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// titleFilter matches item titles against the "include" and "exclude"
+// options. Each option holds one or more regular expressions separated by
+// new lines, an item is kept if it matches any include pattern and none of
+// the exclude patterns. Set "ignore_case" to true for case-insensitive
+// matching.
+type titleFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func parseTitleFilter(options map[string]string) (*titleFilter, error) {
+	ignoreCase, _ := strconv.ParseBool(options["ignore_case"])
+	include, err := compilePatterns(options["include"], ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compilePatterns(options["exclude"], ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+	return &titleFilter{include: include, exclude: exclude}, nil
+}
+
+func compilePatterns(s string, ignoreCase bool) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, p := range SplitPatterns(s) {
+		if ignoreCase {
+			p = "(?i)" + p
+		}
+		reg, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, reg)
+	}
+	return patterns, nil
+}
+
+// SplitPatterns splits a multi pattern option value into its patterns.
+func SplitPatterns(s string) []string {
+	patterns := []string{}
+	for _, p := range strings.Split(s, "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func (f *titleFilter) match(title string) bool {
+	return f.reason(title) == ""
+}
+
+// reason returns why the title is filtered out, or "" if it is kept.
+func (f *titleFilter) reason(title string) string {
+	if len(f.include) > 0 && !matchAny(f.include, title) {
+		return ReasonInclude
+	}
+	if matchAny(f.exclude, title) {
+		return ReasonExclude
+	}
+	return ""
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, p := range patterns {
+		if p.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateOptions checks the options understood by Poll.
+func ValidateOptions(options map[string]string) error {
+	if s, ok := options["ignore_case"]; ok {
+		if _, err := strconv.ParseBool(s); err != nil {
+			return errors.New("invalid ignore_case: " + s)
+		}
+	}
+	if _, err := parseTitleFilter(options); err != nil {
+		return err
+	}
+	c, err := ParseClientConfig(options)
+	if err != nil {
+		return err
+	}
+	_, err = newClient(c)
+	return err
+}
@@ -0,0 +1,48 @@
+package poller
+
+import "testing"
+
+func TestTitleFilter(t *testing.T) {
+	f, err := parseTitleFilter(map[string]string{
+		"include":     "1080p\n  \n\\[(GroupA|GroupB)\\]",
+		"exclude":     "hevc|x265",
+		"ignore_case": "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"[GroupA] Show - 01 [1080P]":      true,
+		"[GroupC] Show - 01 [720p]":       false,
+		"[groupb] Show - 01 [720p]":       true,
+		"[GroupA] Show - 01 [1080p HEVC]": false,
+		"[GroupC] Show - 01 [1080p x265]": false,
+	}
+	for title, want := range cases {
+		if got := f.match(title); got != want {
+			t.Errorf("match(%q) = %v; want %v", title, got, want)
+		}
+	}
+}
+
+func TestTitleFilterCaseSensitive(t *testing.T) {
+	f, err := parseTitleFilter(map[string]string{"include": "1080p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.match("Show - 01 [1080P]") {
+		t.Error("case sensitive filter matched different case")
+	}
+	if !f.match("Show - 01 [1080p]") {
+		t.Error("case sensitive filter did not match")
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	if err := ValidateOptions(map[string]string{"exclude": "1080p\n[unclosed"}); err == nil {
+		t.Error("invalid exclude pattern accepted; want error")
+	}
+	if err := ValidateOptions(map[string]string{"include": "(ok)"}); err != nil {
+		t.Errorf("valid pattern rejected: %v", err)
+	}
+}
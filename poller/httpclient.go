@@ -0,0 +1,284 @@
+package poller
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientConfig configures the HTTP client used to fetch feeds and torrents.
+type ClientConfig struct {
+	// http, https or socks5 proxy url
+	Proxy string
+	// extra request headers
+	Headers   http.Header
+	UserAgent string
+	// cookies file in the Netscape format, as exported by browsers and curl
+	CookieFile string
+	// directory of the cookies files subscriptions can select by name with
+	// the cookie_file option, empty to disallow the option
+	CookieDir          string
+	Timeout            time.Duration
+	InsecureSkipVerify bool
+}
+
+var (
+	defaultClientConfig ClientConfig
+	clients             = struct {
+		sync.Mutex
+		m map[string]*cachedClient
+	}{m: make(map[string]*cachedClient)}
+)
+
+// cachedClient is a shared client, with the modification time of the cookie
+// file it was created from.
+type cachedClient struct {
+	client      *http.Client
+	cookieMtime time.Time
+}
+
+// SetDefaultClientConfig sets the configuration of subscriptions which do not
+// override it in their options.
+func SetDefaultClientConfig(c ClientConfig) error {
+	if _, err := newClient(c); err != nil {
+		return err
+	}
+	clients.Lock()
+	defer clients.Unlock()
+	defaultClientConfig = c
+	clients.m = make(map[string]*cachedClient)
+	return nil
+}
+
+// ParseClientConfig returns the default configuration overridden by the
+// options "proxy", "headers" (one "Name: value" per line), "user_agent",
+// "cookie_file" (a file name in the cookie directory), "timeout" and
+// "insecure_skip_verify".
+func ParseClientConfig(options map[string]string) (ClientConfig, error) {
+	clients.Lock()
+	c := defaultClientConfig
+	clients.Unlock()
+	if s, ok := options["proxy"]; ok {
+		c.Proxy = s
+	}
+	if s, ok := options["headers"]; ok {
+		headers, err := ParseHeaders(s)
+		if err != nil {
+			return c, err
+		}
+		merged := c.Headers.Clone()
+		if merged == nil {
+			merged = http.Header{}
+		}
+		for k, v := range headers {
+			merged[k] = v
+		}
+		c.Headers = merged
+	}
+	if s, ok := options["user_agent"]; ok {
+		c.UserAgent = s
+	}
+	if s, ok := options["cookie_file"]; ok {
+		path, err := cookieFilePath(c.CookieDir, s)
+		if err != nil {
+			return c, err
+		}
+		c.CookieFile = path
+	}
+	if s, ok := options["timeout"]; ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return c, errors.New("invalid timeout: " + s)
+		}
+		c.Timeout = d
+	}
+	if s, ok := options["insecure_skip_verify"]; ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return c, errors.New("invalid insecure_skip_verify: " + s)
+		}
+		c.InsecureSkipVerify = b
+	}
+	return c, nil
+}
+
+// cookieFilePath resolves the cookie_file option, which must name a file in
+// dir so that subscriptions cannot read arbitrary files.
+func cookieFilePath(dir, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	if dir == "" {
+		return "", errors.New("cookie_file requires a cookie directory")
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", errors.New("invalid cookie_file: " + name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// ParseHeaders parses "Name: value" lines.
+func ParseHeaders(s string) (http.Header, error) {
+	headers := http.Header{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, errors.New("invalid header: " + line)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// Client returns the HTTP client for a subscription with the given options.
+// Clients are shared between subscriptions with the same configuration.
+func Client(options map[string]string) (*http.Client, error) {
+	client, _, err := clientOf(options)
+	return client, err
+}
+
+// clientOf returns the client for the options along with the key of its
+// configuration.
+func clientOf(options map[string]string) (*http.Client, string, error) {
+	c, err := ParseClientConfig(options)
+	if err != nil {
+		return nil, "", err
+	}
+	key := c.key()
+	mtime := c.cookieMtime()
+	clients.Lock()
+	defer clients.Unlock()
+	old, ok := clients.m[key]
+	if ok && old.cookieMtime.Equal(mtime) {
+		return old.client, key, nil
+	}
+	client, err := newClient(c)
+	if err != nil {
+		return nil, "", err
+	}
+	if ok {
+		// the cookie file was modified, the new client replaces the old one
+		old.client.CloseIdleConnections()
+	}
+	clients.m[key] = &cachedClient{client: client, cookieMtime: mtime}
+	return client, key, nil
+}
+
+// key identifies a configuration.
+func (c ClientConfig) key() string {
+	return fmt.Sprintf("%s|%v|%s|%s|%s|%v", c.Proxy, c.Headers, c.UserAgent, c.CookieFile, c.Timeout, c.InsecureSkipVerify)
+}
+
+// cookieMtime returns the modification time of the cookie file, the file is
+// loaded again when it changes.
+func (c ClientConfig) cookieMtime() time.Time {
+	if c.CookieFile == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(c.CookieFile)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func newClient(c ClientConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return nil, errors.New("invalid proxy: " + c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if c.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{
+		Timeout:   c.Timeout,
+		Transport: &headerTransport{base: transport, headers: c.Headers, userAgent: c.UserAgent},
+	}
+	if c.CookieFile != "" {
+		jar, err := loadCookieFile(c.CookieFile)
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+	}
+	return client, nil
+}
+
+// headerTransport adds the configured headers to each request.
+type headerTransport struct {
+	base      http.RoundTripper
+	headers   http.Header
+	userAgent string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) == 0 && t.userAgent == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header[k] = v
+	}
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// loadCookieFile reads a Netscape cookies file into a cookie jar.
+func loadCookieFile(file string) (http.CookieJar, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			// the line is not quoted, it may be a secret of another file
+			return nil, fmt.Errorf("invalid cookie file %s at line %d", file, n)
+		}
+		domain, includeSubdomains, path, secure, expires, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+		cookie := &http.Cookie{Name: name, Value: value, Path: path, Secure: secure == "TRUE"}
+		if includeSubdomains == "TRUE" {
+			cookie.Domain = domain
+		}
+		if n, err := strconv.ParseInt(expires, 10, 64); err == nil && n > 0 {
+			cookie.Expires = time.Unix(n, 0)
+		}
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: path}, []*http.Cookie{cookie})
+	}
+	return jar, scanner.Err()
+}
@@ -0,0 +1,156 @@
+package poller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientOptions(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer srv.Close()
+
+	cookieDir := t.TempDir()
+	cookieFile := filepath.Join(cookieDir, "cookies.txt")
+	cookies := "# Netscape HTTP Cookie File\n127.0.0.1\tFALSE\t/\tFALSE\t0\tpasskey\tsecret\n"
+	if err := os.WriteFile(cookieFile, []byte(cookies), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDefaultClientConfig(ClientConfig{UserAgent: "default-agent", Headers: http.Header{"X-Default": {"1"}}, CookieDir: cookieDir}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDefaultClientConfig(ClientConfig{})
+
+	client, err := Client(map[string]string{
+		"headers":     "X-Token: abc\nX-Other: def",
+		"user_agent":  "custom-agent",
+		"cookie_file": "cookies.txt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if ua := got.Header.Get("User-Agent"); ua != "custom-agent" {
+		t.Errorf("User-Agent = %q; want custom-agent", ua)
+	}
+	if got.Header.Get("X-Token") != "abc" || got.Header.Get("X-Other") != "def" || got.Header.Get("X-Default") != "1" {
+		t.Errorf("headers = %v; want subscription and default headers", got.Header)
+	}
+	if c, err := got.Cookie("passkey"); err != nil || c.Value != "secret" {
+		t.Errorf("passkey cookie = %v, %v; want secret", c, err)
+	}
+
+	// the default configuration applies without options
+	client, err = Client(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if ua := got.Header.Get("User-Agent"); ua != "default-agent" {
+		t.Errorf("User-Agent = %q; want default-agent", ua)
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	var host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.URL.Host
+	}))
+	defer proxy.Close()
+	client, err := Client(map[string]string{"proxy": proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get("http://tracker.example/rss"); err != nil {
+		t.Fatal(err)
+	}
+	if host != "tracker.example" {
+		t.Errorf("proxied host = %q; want tracker.example", host)
+	}
+}
+
+func TestValidateClientOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"proxy": "ftp://proxy"},
+		{"headers": "no colon"},
+		{"timeout": "soon"},
+		{"insecure_skip_verify": "maybe"},
+		// without a cookie directory
+		{"cookie_file": "cookies.txt"},
+	} {
+		if err := ValidateOptions(options); err == nil {
+			t.Errorf("ValidateOptions(%v) succeeded; want error", options)
+		}
+	}
+}
+
+func TestCookieFileOption(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.txt"), []byte("secret line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDefaultClientConfig(ClientConfig{CookieDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDefaultClientConfig(ClientConfig{})
+	for _, name := range []string{"/etc/passwd", "../cookies.txt", "..", "missing.txt"} {
+		if err := ValidateOptions(map[string]string{"cookie_file": name}); err == nil {
+			t.Errorf("cookie_file %q accepted; want error", name)
+		}
+	}
+	err := ValidateOptions(map[string]string{"cookie_file": "broken.txt"})
+	if err == nil || strings.Contains(err.Error(), "secret") || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("error = %v; want line number without the content", err)
+	}
+}
+
+func TestClientReloadsCookieFile(t *testing.T) {
+	dir := t.TempDir()
+	cookieFile := filepath.Join(dir, "cookies.txt")
+	if err := os.WriteFile(cookieFile, []byte("127.0.0.1\tFALSE\t/\tFALSE\t0\tpasskey\tv1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDefaultClientConfig(ClientConfig{CookieDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDefaultClientConfig(ClientConfig{})
+	options := map[string]string{"cookie_file": "cookies.txt"}
+	first, err := Client(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := Client(options); again != first {
+		t.Error("client not shared while the cookie file is unchanged")
+	}
+	if err := os.WriteFile(cookieFile, []byte("127.0.0.1\tFALSE\t/\tFALSE\t0\tpasskey\tv2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(cookieFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := Client(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == first {
+		t.Error("cookie file not reloaded")
+	}
+	clients.Lock()
+	n := len(clients.m)
+	clients.Unlock()
+	if n != 1 {
+		t.Errorf("%d cached clients; want the old one replaced", n)
+	}
+}
@@ -0,0 +1,83 @@
+package magnet
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+const btihPrefix = "urn:btih:"
+
+type magnetPoller struct {
+}
+
+func init() {
+	poller.RegisterPuller(&magnetPoller{})
+}
+
+func (p *magnetPoller) Poll(ctx context.Context, rss *poller.RSSItem, options map[string]string) (*poller.Job, bool, error) {
+	link := findMagnetLink(rss)
+	if link == "" {
+		return nil, false, nil
+	}
+	infoHash, err := parseInfoHash(link)
+	if err != nil {
+		return nil, true, err
+	}
+	return &poller.Job{
+		Type:     "magnet",
+		Content:  link,
+		InfoHash: infoHash,
+	}, true, nil
+}
+
+func findMagnetLink(rss *poller.RSSItem) string {
+	for _, link := range []string{rss.Enclosure.URL, rss.Link, rss.Entry.Link} {
+		link = strings.TrimSpace(link)
+		if isMagnetLink(link) {
+			return link
+		}
+	}
+	return ""
+}
+
+func isMagnetLink(link string) bool {
+	return strings.HasPrefix(strings.ToLower(link), "magnet:")
+}
+
+// parseInfoHash extracts the btih of a magnet link and returns it as a
+// lower case hex string, both hex and base32 encoded hashes are accepted.
+func parseInfoHash(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	for _, xt := range u.Query()["xt"] {
+		if len(xt) < len(btihPrefix) || !strings.EqualFold(xt[:len(btihPrefix)], btihPrefix) {
+			continue
+		}
+		hash := xt[len(btihPrefix):]
+		switch len(hash) {
+		case 40:
+			b, err := hex.DecodeString(hash)
+			if err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(b), nil
+		case 32:
+			b, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+			if err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(b), nil
+		default:
+			return "", errors.New("invalid btih length: " + hash)
+		}
+	}
+	return "", errors.New("missing btih in magnet link")
+}
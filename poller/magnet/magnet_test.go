@@ -0,0 +1,29 @@
+package magnet
+
+import "testing"
+
+func TestParseInfoHashHex(t *testing.T) {
+	infoHash, err := parseInfoHash("magnet:?xt=urn:btih:245211C98E3F5D99CB9CF306E1133F134DBD0BCC&dn=test&tr=http%3A%2F%2Ftracker.example%2Fannounce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infoHash != "245211c98e3f5d99cb9cf306e1133f134dbd0bcc" {
+		t.Errorf("infoHash = %s; want 245211c98e3f5d99cb9cf306e1133f134dbd0bcc", infoHash)
+	}
+}
+
+func TestParseInfoHashBase32(t *testing.T) {
+	infoHash, err := parseInfoHash("magnet:?xt=urn:btih:erjbdsmoh5ozts446mdocez7cng32c6m&dn=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infoHash != "245211c98e3f5d99cb9cf306e1133f134dbd0bcc" {
+		t.Errorf("infoHash = %s; want 245211c98e3f5d99cb9cf306e1133f134dbd0bcc", infoHash)
+	}
+}
+
+func TestParseInfoHashMissing(t *testing.T) {
+	if _, err := parseInfoHash("magnet:?dn=test"); err == nil {
+		t.Error("parseInfoHash without xt succeeded; want error")
+	}
+}
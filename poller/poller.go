@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/xml"
 	"errors"
+	"io"
 	"log"
 	"net/http"
 	"slices"
@@ -33,10 +34,24 @@ func (w *Work) RemoveCompletedJob(completed []string) {
 	}
 }
 
+// FindJobs returns the jobs with the given info hashes.
+func (w *Work) FindJobs(infoHashes []string) []*Job {
+	jobs := []*Job{}
+	for _, job := range w.Jobs {
+		if slices.Contains(infoHashes, job.InfoHash) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
 type Job struct {
 	Type     string
 	Content  string
 	InfoHash string
+	Title    string
+	Size     int64
+	Episode  *Episode
 }
 
 type RSSWrapper struct {
@@ -76,10 +91,54 @@ func RegisterPuller(poller Poller) {
 	pollers = append(pollers, poller)
 }
 
+// reasons of skipping a feed item, reported by Preview
+const (
+	ReasonFilter         = "filter"
+	ReasonInclude        = "include"
+	ReasonExclude        = "exclude"
+	ReasonTime           = "time"
+	ReasonSize           = "size"
+	ReasonPollerNotFound = "poller not found"
+	ReasonPollFailed     = "poll failed"
+)
+
+var errPollerNotFound = errors.New("poller not found")
+
+// ItemResult is the decision made for a feed item.
+type ItemResult struct {
+	Title    string `json:"title"`
+	PubDate  string `json:"pub_date,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	InfoHash string `json:"info_hash,omitempty"`
+	Matched  bool   `json:"matched"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Job      *Job   `json:"-"`
+}
+
 func Poll(ctx context.Context, rssURL string, options map[string]string) (*Work, error) {
-	rss, err := fetchRSS(ctx, rssURL)
+	w, _, err := pollFeed(ctx, rssURL, options)
+	return w, err
+}
+
+// Preview polls the feed like Poll, and also returns the decision made for
+// every item of the feed.
+func Preview(ctx context.Context, rssURL string, options map[string]string) (*Work, []*ItemResult, error) {
+	return pollFeed(ctx, rssURL, options)
+}
+
+func pollFeed(ctx context.Context, rssURL string, options map[string]string) (*Work, []*ItemResult, error) {
+	titleFilter, err := parseTitleFilter(options)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	client, clientKey, err := clientOf(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	rss, err := fetchRSS(ctx, client, clientKey, rssURL)
+	if err != nil {
+		return nil, nil, err
 	}
 	w := &Work{
 		Name:     strings.TrimSpace(rss.Title),
@@ -96,21 +155,49 @@ func Poll(ctx context.Context, rssURL string, options map[string]string) (*Work,
 		n, err := strconv.ParseUint(s, 10, 64)
 		return n, err == nil
 	}()
+	results := make([]*ItemResult, 0, len(rss.Items))
 	for _, item := range rss.Items {
+		result := &ItemResult{
+			Title:   item.Title,
+			PubDate: item.Entry.PubDate,
+			Size:    itemSize(item),
+		}
+		results = append(results, result)
 		if nameFilterEnable && !strings.Contains(item.Title, nameFilter) {
+			result.Reason = ReasonFilter
+			continue
+		}
+		if reason := titleFilter.reason(item.Title); reason != "" {
+			result.Reason = reason
 			continue
 		}
 		if timeFilterEnable && timeSmallerThan(item.Entry.PubDate, timeFilter) {
+			result.Reason = ReasonTime
 			continue
 		}
 		if sizeFilterEnable && item.Entry.ContentLength > sizeFilter {
+			result.Reason = ReasonSize
 			continue
 		}
 		job, err := pollItem(ctx, item, options)
+		if err == errPollerNotFound {
+			result.Reason = ReasonPollerNotFound
+			continue
+		}
 		if err != nil {
 			log.Printf("ignore poll failed item with error: %s, title: %s, type: %s, url: %s\n", err, item.Title, item.Enclosure.Type, item.Enclosure.URL)
+			result.Reason = ReasonPollFailed
+			result.Error = err.Error()
 			continue
 		}
+		job.Title = item.Title
+		job.Size = result.Size
+		if ep, ok := ParseEpisode(item.Title); ok {
+			job.Episode = ep
+		}
+		result.InfoHash = job.InfoHash
+		result.Matched = true
+		result.Job = job
 		w.Jobs = append(w.Jobs, job)
 	}
 	if trim, ok := options["trim"]; ok {
@@ -119,27 +206,88 @@ func Poll(ctx context.Context, rssURL string, options map[string]string) (*Work,
 		w.Name = strings.TrimSpace(w.Name)
 	}
 	w.Name = formatFileName(w.Name)
-	return w, nil
+	return w, results, nil
+}
+
+func itemSize(item *RSSItem) int64 {
+	if item.Entry.ContentLength > 0 {
+		return int64(item.Entry.ContentLength)
+	}
+	return item.Enclosure.Length
 }
 
-func fetchRSS(ctx context.Context, rssURL string) (*RSS, error) {
+// fetchRSS sends a conditional request if the feed was fetched before with
+// the same client configuration, and reuses the feed decoded last time if it
+// is not modified.
+func fetchRSS(ctx context.Context, client *http.Client, clientKey string, rssURL string) (*RSS, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", rssURL, nil)
 	if err != nil {
 		return nil, err
 	}
-	res, err := http.DefaultClient.Do(req)
+	cached := getCachedFeed(clientKey, rssURL)
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
-	decoder := xml.NewDecoder(res.Body)
-	rssWrapper := &RSSWrapper{
-		RSS: &RSS{},
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		return copyRSS(cached.rss), nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("fetch feed failed: " + res.Status)
 	}
-	if err := decoder.Decode(rssWrapper); err != nil {
+	rss, err := decodeFeed(res.Body)
+	if err != nil {
 		return nil, err
 	}
-	return rssWrapper.RSS, nil
+	putCachedFeed(clientKey, rssURL, &cachedFeed{
+		etag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+		rss:          copyRSS(rss),
+	})
+	return rss, nil
+}
+
+// decodeFeed sniffs the root element of the document and decodes it as
+// either an RSS or an Atom feed.
+func decodeFeed(r io.Reader) (*RSS, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "rss":
+			rssWrapper := &RSSWrapper{
+				RSS: &RSS{},
+			}
+			if err := decoder.DecodeElement(rssWrapper, &start); err != nil {
+				return nil, err
+			}
+			return rssWrapper.RSS, nil
+		case "feed":
+			atom := &AtomFeed{}
+			if err := decoder.DecodeElement(atom, &start); err != nil {
+				return nil, err
+			}
+			return atom.toRSS(), nil
+		default:
+			return nil, errors.New("unsupported feed format: " + start.Name.Local)
+		}
+	}
 }
 
 func pollItem(ctx context.Context, item *RSSItem, options map[string]string) (*Job, error) {
@@ -153,7 +301,7 @@ func pollItem(ctx context.Context, item *RSSItem, options map[string]string) (*J
 			return job, nil
 		}
 	}
-	return nil, errors.New("poller not found")
+	return nil, errPollerNotFound
 }
 
 func timeSmallerThan(t1, t2 string) bool {
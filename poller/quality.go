@@ -0,0 +1,163 @@
+package poller
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Release holds the quality attributes parsed from an item title, values
+// are normalized to lower case and empty if unknown.
+type Release struct {
+	Resolution string
+	Codec      string
+	Source     string
+	Group      string
+}
+
+var (
+	resolutionReg = regexp.MustCompile(`(?i)\b(2160p|4k|1080p|1080i|720p|576p|480p)\b|\b(3840x2160|1920x1080|1280x720)\b`)
+	codecReg      = regexp.MustCompile(`(?i)\b(hevc|[hx]\.?265|avc|[hx]\.?264|av1|vp9)\b`)
+	sourceReg     = regexp.MustCompile(`(?i)\b(blu-?ray|bdrip|bd|web-?dl|webrip|web|hdtv|dvdrip|dvd)\b`)
+	headGroupReg  = regexp.MustCompile(`^\s*[\[【]([^\]】]+)[\]】]`)
+	tailGroupReg  = regexp.MustCompile(`-([A-Za-z0-9]+)(?:\.[A-Za-z0-9]{2,4})?\s*$`)
+)
+
+var attributeAliases = map[string]string{
+	"4k":        "2160p",
+	"3840x2160": "2160p",
+	"1920x1080": "1080p",
+	"1080i":     "1080p",
+	"1280x720":  "720p",
+	"h265":      "hevc",
+	"x265":      "hevc",
+	"h.265":     "hevc",
+	"x.265":     "hevc",
+	"h264":      "avc",
+	"x264":      "avc",
+	"h.264":     "avc",
+	"x.264":     "avc",
+	"blu-ray":   "bluray",
+	"bdrip":     "bluray",
+	"bd":        "bluray",
+	"web-dl":    "webdl",
+	"web":       "webdl",
+	"dvd":       "dvdrip",
+}
+
+func normalizeAttribute(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if alias, ok := attributeAliases[s]; ok {
+		return alias
+	}
+	return s
+}
+
+// ParseRelease extracts resolution, codec, source and release group from a
+// title like "[Group] Show - 01 [1080p][HEVC]" or "Show.S01E01.1080p.WEB-DL.x264-GROUP".
+func ParseRelease(title string) Release {
+	var r Release
+	if m := resolutionReg.FindString(title); m != "" {
+		r.Resolution = normalizeAttribute(m)
+	}
+	if m := codecReg.FindString(title); m != "" {
+		r.Codec = normalizeAttribute(m)
+	}
+	if m := sourceReg.FindString(title); m != "" {
+		r.Source = normalizeAttribute(m)
+	}
+	if m := headGroupReg.FindStringSubmatch(title); m != nil {
+		r.Group = strings.ToLower(strings.TrimSpace(m[1]))
+	} else if m := tailGroupReg.FindStringSubmatch(title); m != nil {
+		r.Group = strings.ToLower(m[1])
+	}
+	return r
+}
+
+// Profile is the release preference of a subscription, each list is ordered
+// from the most to the least preferred value. Attributes are compared in the
+// order resolution, codec, source, group.
+type Profile struct {
+	Resolutions []string
+	Codecs      []string
+	Sources     []string
+	Groups      []string
+}
+
+// ParseProfile reads the "prefer_resolution", "prefer_codec",
+// "prefer_source" and "prefer_group" options, each a comma separated list.
+// ok is false if none of them is set.
+func ParseProfile(options map[string]string) (*Profile, bool) {
+	p := &Profile{
+		Resolutions: splitPreference(options["prefer_resolution"]),
+		Codecs:      splitPreference(options["prefer_codec"]),
+		Sources:     splitPreference(options["prefer_source"]),
+		Groups:      splitPreference(options["prefer_group"]),
+	}
+	ok := len(p.Resolutions)+len(p.Codecs)+len(p.Sources)+len(p.Groups) > 0
+	return p, ok
+}
+
+func splitPreference(s string) []string {
+	values := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = normalizeAttribute(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Compare returns a negative number when title a is preferred over title b,
+// a positive number when b is preferred and 0 if they rank the same.
+func (p *Profile) Compare(a, b string) int {
+	ra, rb := p.rank(ParseRelease(a)), p.rank(ParseRelease(b))
+	for i := range ra {
+		if ra[i] != rb[i] {
+			return ra[i] - rb[i]
+		}
+	}
+	return 0
+}
+
+func (p *Profile) rank(r Release) [4]int {
+	return [4]int{
+		preferenceIndex(p.Resolutions, r.Resolution),
+		preferenceIndex(p.Codecs, r.Codec),
+		preferenceIndex(p.Sources, r.Source),
+		preferenceIndex(p.Groups, r.Group),
+	}
+}
+
+// preferenceIndex ranks values missing from the list after all listed ones.
+func preferenceIndex(list []string, v string) int {
+	for i, s := range list {
+		if s == v {
+			return i
+		}
+	}
+	return len(list)
+}
+
+// KeepBestReleases keeps only the best ranked job of each episode, jobs
+// without an episode number and pinned jobs are always kept and do not take
+// part in the ranking. The order of the remaining jobs is not changed.
+func (w *Work) KeepBestReleases(p *Profile, pinned []string) {
+	best := map[string]*Job{}
+	for _, job := range w.Jobs {
+		if job.Episode == nil || slices.Contains(pinned, job.InfoHash) {
+			continue
+		}
+		key := job.Episode.String()
+		if b, ok := best[key]; !ok || p.Compare(job.Title, b.Title) < 0 {
+			best[key] = job
+		}
+	}
+	jobs := []*Job{}
+	for _, job := range w.Jobs {
+		if job.Episode == nil || slices.Contains(pinned, job.InfoHash) || best[job.Episode.String()] == job {
+			jobs = append(jobs, job)
+		}
+	}
+	w.Jobs = jobs
+}
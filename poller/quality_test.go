@@ -0,0 +1,46 @@
+package poller
+
+import "testing"
+
+func TestParseRelease(t *testing.T) {
+	cases := map[string]Release{
+		"[GroupA] Show - 01 [1080p][HEVC]":            {Resolution: "1080p", Codec: "hevc", Group: "groupa"},
+		"Show.S01E01.2160p.WEB-DL.x264-GROUP":         {Resolution: "2160p", Codec: "avc", Source: "webdl", Group: "group"},
+		"【GroupB】Show 05 [BDRip 1920x1080 x265 FLAC]": {Resolution: "1080p", Codec: "hevc", Source: "bluray", Group: "groupb"},
+		"Show 05": {},
+	}
+	for title, want := range cases {
+		if got := ParseRelease(title); got != want {
+			t.Errorf("ParseRelease(%q) = %+v; want %+v", title, got, want)
+		}
+	}
+}
+
+func TestKeepBestReleases(t *testing.T) {
+	p, ok := ParseProfile(map[string]string{
+		"prefer_resolution": "1080p, 720p",
+		"prefer_codec":      "x264",
+		"prefer_group":      "GroupB,GroupA",
+	})
+	if !ok {
+		t.Fatal("profile not parsed")
+	}
+	ep := func(n int) *Episode { return &Episode{Season: 1, Start: n, End: n} }
+	w := &Work{Jobs: []*Job{
+		{InfoHash: "a", Title: "[GroupA] Show - 01 [720p]", Episode: ep(1)},
+		{InfoHash: "b", Title: "[GroupA] Show - 01 [1080p][HEVC]", Episode: ep(1)},
+		{InfoHash: "c", Title: "[GroupA] Show - 01 [1080p][x264]", Episode: ep(1)},
+		{InfoHash: "d", Title: "[GroupB] Show - 01 [1080p][x264]", Episode: ep(1)},
+		{InfoHash: "e", Title: "[GroupA] Show - 02 [2160p]", Episode: ep(2)},
+		{InfoHash: "f", Title: "[GroupA] Show - 02 [720p]", Episode: ep(2)},
+		{InfoHash: "g", Title: "Show OVA"},
+	}}
+	w.KeepBestReleases(p, []string{"a"})
+	got := ""
+	for _, job := range w.Jobs {
+		got += job.InfoHash
+	}
+	if got != "adfg" {
+		t.Errorf("kept jobs = %s; want adfg", got)
+	}
+}
@@ -0,0 +1,120 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cache entries not used for this long are removed by SetCacheDir
+const cacheMaxAge = time.Hour * 24 * 90
+
+// cacheEntry is a .torrent file fetched from URL and its info hash.
+type cacheEntry struct {
+	URL      string `json:"url"`
+	InfoHash string `json:"info_hash"`
+	Data     []byte `json:"data"`
+}
+
+var cache struct {
+	sync.Mutex
+	dir string
+}
+
+// SetCacheDir enables caching fetched .torrent files in dir, so that items
+// seen before are not downloaded again. An empty dir disables the cache.
+func SetCacheDir(dir string) error {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.dir = dir
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	pruneCache(dir, time.Now().Add(-cacheMaxAge))
+	return nil
+}
+
+func cachePath(dir, url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func loadCached(url string) *cacheEntry {
+	cache.Lock()
+	dir := cache.dir
+	cache.Unlock()
+	if dir == "" {
+		return nil
+	}
+	p := cachePath(dir, url)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil || entry.URL != url {
+		return nil
+	}
+	// the modification time tells when the entry was last used
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return &entry
+}
+
+func storeCached(entry *cacheEntry) {
+	cache.Lock()
+	dir := cache.dir
+	cache.Unlock()
+	if dir == "" {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	p := cachePath(dir, entry.URL)
+	// written to a temporary file first, a partial entry is never read
+	tmp, err := os.CreateTemp(dir, ".tmp*")
+	if err != nil {
+		log.Printf("torrent cache write error: %s\n", err)
+		return
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("torrent cache write error: %s\n", err)
+	}
+}
+
+// pruneCache removes entries last used before t.
+func pruneCache(dir string, t time.Time) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !(strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".tmp")) {
+			continue
+		}
+		info, err := e.Info()
+		if err == nil && info.ModTime().Before(t) {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
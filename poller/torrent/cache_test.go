@@ -0,0 +1,39 @@
+package torrent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestGetTorrentCached(t *testing.T) {
+	data, err := os.ReadFile("testdata/a.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	if err := SetCacheDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCacheDir("")
+	for i := 0; i < 2; i++ {
+		entry, err := getTorrent(context.Background(), http.DefaultClient, srv.URL+"/a.torrent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.InfoHash != "245211c98e3f5d99cb9cf306e1133f134dbd0bcc" || len(entry.Data) != len(data) {
+			t.Errorf("get %d: info hash = %s, %d bytes; want cached torrent", i, entry.InfoHash, len(entry.Data))
+		}
+	}
+	if fetched != 1 {
+		t.Errorf("fetched %d times; want 1", fetched)
+	}
+}
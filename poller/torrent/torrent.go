@@ -9,6 +9,7 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/jackpal/bencode-go"
 	"github.com/lonord/rss-torrent-downloader/poller"
@@ -22,24 +23,42 @@ func init() {
 }
 
 func (p *torrentPoller) Poll(ctx context.Context, rss *poller.RSSItem, options map[string]string) (*poller.Job, bool, error) {
-	if rss.Enclosure.Type != "application/x-bittorrent" {
+	if rss.Enclosure.Type != "application/x-bittorrent" || strings.HasPrefix(strings.ToLower(strings.TrimSpace(rss.Enclosure.URL)), "magnet:") {
 		return nil, false, nil
 	}
-	torrentData, err := fetchTorrent(ctx, rss.Enclosure.URL)
+	client, err := poller.Client(options)
 	if err != nil {
 		return nil, true, err
 	}
-	infoHash, err := calculateInfoHash(torrentData)
+	entry, err := getTorrent(ctx, client, rss.Enclosure.URL)
 	if err != nil {
 		return nil, true, err
 	}
 	return &poller.Job{
 		Type:     "torrent",
-		Content:  base64.StdEncoding.EncodeToString(torrentData),
-		InfoHash: infoHash,
+		Content:  base64.StdEncoding.EncodeToString(entry.Data),
+		InfoHash: entry.InfoHash,
 	}, true, nil
 }
 
+// getTorrent returns the torrent of url from the cache, or fetches it.
+func getTorrent(ctx context.Context, client *http.Client, url string) (*cacheEntry, error) {
+	if entry := loadCached(url); entry != nil {
+		return entry, nil
+	}
+	torrentData, err := fetchTorrent(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	infoHash, err := calculateInfoHash(torrentData)
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{URL: url, InfoHash: infoHash, Data: torrentData}
+	storeCached(entry)
+	return entry, nil
+}
+
 func calculateInfoHash(torrentContent []byte) (string, error) {
 	torrentDataReader := bytes.NewReader(torrentContent)
 	result, err := bencode.Decode(torrentDataReader)
@@ -72,12 +91,12 @@ func calculateInfoHash(torrentContent []byte) (string, error) {
 	return hashString, nil
 }
 
-func fetchTorrent(ctx context.Context, url string) ([]byte, error) {
+func fetchTorrent(ctx context.Context, client *http.Client, url string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	res, err := http.DefaultClient.Do(req)
+	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
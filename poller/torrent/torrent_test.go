@@ -1,9 +1,12 @@
 package torrent
 
 import (
+	"context"
 	"io"
 	"os"
 	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
 )
 
 func TestCalculateInfoHash1(t *testing.T) {
@@ -43,3 +46,13 @@ func TestCalculateInfoHash2(t *testing.T) {
 		t.Errorf("infoHash = %s; want 448057b2e83c50287c861697872888f75741f9ec", infoHash)
 	}
 }
+
+func TestPollSkipsMagnetEnclosure(t *testing.T) {
+	rss := &poller.RSSItem{}
+	rss.Enclosure.Type = "application/x-bittorrent"
+	rss.Enclosure.URL = " MAGNET:?xt=urn:btih:245211c98e3f5d99cb9cf306e1133f134dbd0bcc"
+	job, ok, err := (&torrentPoller{}).Poll(context.Background(), rss, nil)
+	if job != nil || ok || err != nil {
+		t.Errorf("Poll = %v, %v, %v; want the magnet link left to the magnet poller", job, ok, err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/worker"
+)
+
+// runPreview implements the preview subcommand:
+//
+//	rss-torrent-dl [flags] preview <url> [name=<id>] [option=value ...]
+func runPreview(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: " + appName + " [flags] preview <url> [name=<id>] [option=value ...]")
+	}
+	rssURL := args[0]
+	options := map[string]string{}
+	id := ""
+	for _, arg := range args[1:] {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return errors.New("invalid option, want key=value: " + arg)
+		}
+		if k == "name" {
+			id = v
+			continue
+		}
+		if k == "include" || k == "exclude" {
+			if prev, ok := options[k]; ok {
+				v = prev + "\n" + v
+			}
+		}
+		options[k] = v
+	}
+	if id == "" {
+		hash := md5.Sum([]byte(rssURL))
+		id = hex.EncodeToString(hash[:])
+	}
+	// a dry run never imports into or migrates the database
+	subRepo, err := newReadOnlyRepo()
+	if err != nil {
+		return err
+	}
+	w := &worker.Worker{
+		Repo:     subRepo,
+		Interval: time.Minute * time.Duration(flags.interval),
+	}
+	if err := w.ValidateOptions(options); err != nil {
+		return err
+	}
+	entry, err := w.FindEntry(id)
+	if err != nil {
+		return err
+	}
+	preview, err := w.Preview(rssURL, options, entry)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n\n", preview.Name)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tREASON\tTITLE")
+	matched := 0
+	for _, item := range preview.Items {
+		status := "skip"
+		if item.Matched {
+			status = "match"
+			matched++
+		}
+		reason := item.Reason
+		if item.Error != "" {
+			reason += ": " + item.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", status, reason, item.Title)
+	}
+	tw.Flush()
+	fmt.Printf("\n%d items, %d matched\n", len(preview.Items), matched)
+	return nil
+}
@@ -1,7 +1,10 @@
 package repo
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path"
@@ -11,51 +14,148 @@ import (
 	"github.com/lonord/rss-torrent-downloader/worker"
 )
 
-const fExt = ".json"
+const (
+	fExt   = ".json"
+	bakExt = ".bak"
+)
 
 type FileRepo struct {
 	Dir string
 }
 
+// Query calls fn for every subscription file in Dir. Broken files do not stop
+// the scan, they are recovered from the backup if possible and reported in the
+// returned error.
 func (r *FileRepo) Query(fn func(*worker.SubscriptionEntry)) error {
 	dirEntries, err := os.ReadDir(r.Dir)
 	if err != nil {
 		return err
 	}
+	var errs []error
 	for _, dirEntry := range dirEntries {
 		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != fExt {
 			continue
 		}
-		file, err := os.Open(filepath.Join(r.Dir, dirEntry.Name()))
+		p := filepath.Join(r.Dir, dirEntry.Name())
+		entry, err := readEntry(p)
 		if err != nil {
-			log.Println("read file error:", err)
-			return nil
-		}
-		defer file.Close()
-		var entry worker.SubscriptionEntry
-		if err := json.NewDecoder(file).Decode(&entry); err != nil {
-			log.Println("decode file error:", err)
-			return nil
+			log.Printf("read subscription file %s error: %s\n", p, err)
+			errs = append(errs, &worker.EntryError{
+				ID:  strings.TrimSuffix(dirEntry.Name(), fExt),
+				Err: fmt.Errorf("%s: %w", dirEntry.Name(), err),
+			})
+			if entry, err = readEntry(p + bakExt); err != nil {
+				continue
+			}
+			log.Printf("subscription file %s recovered from backup\n", p)
 		}
 		if entry.RssURL != "" {
 			entry.ID = strings.TrimSuffix(dirEntry.Name(), fExt)
-			fn(&entry)
+			fn(entry)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+func readEntry(p string) (*worker.SubscriptionEntry, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var entry worker.SubscriptionEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
 }
 
+// Save writes the entry to a temporary file and renames it over the live
+// file, the previous version is kept with a .bak suffix.
 func (r *FileRepo) Save(entry *worker.SubscriptionEntry) error {
 	p := path.Join(r.Dir, entry.ID+fExt)
-	f, err := os.Create(p)
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if old, err := os.ReadFile(p); err == nil {
+		// never replace a good backup with a broken file
+		if json.Valid(old) && !bytes.Equal(old, b) {
+			if err := writeFileAtomic(p+bakExt, old); err != nil {
+				return err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return writeFileAtomic(p, b)
+}
+
+func writeFileAtomic(p string, data []byte) error {
+	dir, name := filepath.Split(p)
+	f, err := os.CreateTemp(dir, "."+name+".tmp*")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(entry)
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes the directory entry of a renamed file, it is best effort
+// since directories can not be synced on every platform.
+func syncDir(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
 }
 
 func (r *FileRepo) Delete(id string) error {
 	p := path.Join(r.Dir, id+fExt)
-	return os.Remove(p)
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	os.Remove(p + bakExt)
+	return nil
+}
+
+// Rename moves the subscription file and its backup to the new id.
+func (r *FileRepo) Rename(oldID, newID string) error {
+	oldPath := path.Join(r.Dir, oldID+fExt)
+	newPath := path.Join(r.Dir, newID+fExt)
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("subscription %s: %w", newID, os.ErrExist)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if err := os.Rename(oldPath+bakExt, newPath+bakExt); err != nil && !os.IsNotExist(err) {
+		log.Printf("rename backup of %s error: %s\n", oldID, err)
+	}
+	syncDir(r.Dir)
+	return nil
 }
@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/worker"
+)
+
+func TestFileRepoSaveKeepsBackup(t *testing.T) {
+	dir := t.TempDir()
+	r := &FileRepo{Dir: dir}
+	entry := &worker.SubscriptionEntry{ID: "show", RssURL: "https://tracker.example/rss"}
+	if err := r.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	entry.Completed = []string{"aaaa"}
+	if err := r.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	bak, err := readEntry(filepath.Join(dir, "show.json.bak"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bak.Completed) != 0 {
+		t.Errorf("backup completed = %v; want previous version", bak.Completed)
+	}
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".tmp") {
+			t.Errorf("temporary file %s left behind", f.Name())
+		}
+	}
+}
+
+func TestFileRepoQueryBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	r := &FileRepo{Dir: dir}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := r.Save(&worker.SubscriptionEntry{ID: id, RssURL: "https://tracker.example/" + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a truncated file without backup, and one that can be recovered
+	if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"url":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.json.bak"), []byte(`{"url":"https://tracker.example/b"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"url":"htt`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	err := r.Query(func(entry *worker.SubscriptionEntry) {
+		ids = append(ids, entry.ID)
+	})
+	if err == nil || !strings.Contains(err.Error(), "a.json") || !strings.Contains(err.Error(), "b.json") {
+		t.Errorf("query error = %v; want a.json and b.json reported", err)
+	}
+	if strings.Join(ids, ",") != "b,c" {
+		t.Errorf("queried = %v; want [b c]", ids)
+	}
+}
@@ -0,0 +1,376 @@
+package repo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+	"github.com/lonord/rss-torrent-downloader/worker"
+	_ "modernc.org/sqlite"
+)
+
+// migrations are applied in order, the index of the last applied one is
+// stored in PRAGMA user_version
+var migrations = []string{
+	`CREATE TABLE subscriptions (
+		id      TEXT PRIMARY KEY,
+		url     TEXT NOT NULL,
+		options TEXT NOT NULL DEFAULT '{}'
+	);
+	CREATE TABLE history (
+		subscription_id TEXT NOT NULL,
+		info_hash       TEXT NOT NULL,
+		title           TEXT NOT NULL DEFAULT '',
+		size            INTEGER NOT NULL DEFAULT 0,
+		added_at        INTEGER,
+		completed_at    INTEGER,
+		files           TEXT NOT NULL DEFAULT '[]',
+		PRIMARY KEY (subscription_id, info_hash)
+	);`,
+	`ALTER TABLE subscriptions ADD COLUMN episodes TEXT NOT NULL DEFAULT '{}';`,
+	`ALTER TABLE subscriptions ADD COLUMN paused INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE subscriptions ADD COLUMN resume_at INTEGER;`,
+	`ALTER TABLE subscriptions ADD COLUMN failures TEXT NOT NULL DEFAULT '{}';`,
+}
+
+type SQLiteRepo struct {
+	db *sql.DB
+}
+
+func NewSQLiteRepo(path string) (*SQLiteRepo, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer, serialize all access through one connection
+	db.SetMaxOpenConns(1)
+	r := &SQLiteRepo{db: db}
+	if err := r.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenSQLiteRepoReadOnly opens an existing database without migrating it,
+// for callers which must not change it. It fails if the database was not
+// migrated by this version yet.
+func OpenSQLiteRepoReadOnly(path string) (*SQLiteRepo, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version < len(migrations) {
+		db.Close()
+		return nil, fmt.Errorf("database %s has schema version %d, start the downloader once to migrate it to %d", path, version, len(migrations))
+	}
+	return &SQLiteRepo{db: db}, nil
+}
+
+func (r *SQLiteRepo) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLiteRepo) migrate() error {
+	var version int
+	if err := r.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := r.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// PRAGMA does not accept bind parameters
+		if _, err := tx.Exec("PRAGMA user_version = " + strconv.Itoa(version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import copies the subscriptions of src into the database and returns how
+// many were imported. Subscriptions already in the database are skipped, so
+// that importing again on the next start keeps what was recorded since.
+// Entries read before src reports an error are still imported.
+func (r *SQLiteRepo) Import(src worker.SubscriptionRepo) (int, error) {
+	existing := map[string]bool{}
+	if err := r.Query(func(entry *worker.SubscriptionEntry) {
+		existing[entry.ID] = true
+	}); err != nil {
+		return 0, err
+	}
+	entries := []*worker.SubscriptionEntry{}
+	queryErr := src.Query(func(entry *worker.SubscriptionEntry) {
+		if !existing[entry.ID] {
+			entries = append(entries, entry)
+		}
+	})
+	for i, entry := range entries {
+		if err := r.Save(entry); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), queryErr
+}
+
+func (r *SQLiteRepo) Query(fn func(*worker.SubscriptionEntry)) error {
+	entries, err := r.queryEntries()
+	if err != nil {
+		return err
+	}
+	// rows are closed before calling fn, so fn is free to call Save
+	for _, entry := range entries {
+		fn(entry)
+	}
+	return nil
+}
+
+func (r *SQLiteRepo) queryEntries() ([]*worker.SubscriptionEntry, error) {
+	rows, err := r.db.Query("SELECT id, url, options, episodes, paused, resume_at, failures FROM subscriptions ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*worker.SubscriptionEntry{}
+	entryMap := map[string]*worker.SubscriptionEntry{}
+	for rows.Next() {
+		var entry worker.SubscriptionEntry
+		var options, episodes, failures string
+		var resumeAt sql.NullInt64
+		if err := rows.Scan(&entry.ID, &entry.RssURL, &options, &episodes, &entry.Paused, &resumeAt, &failures); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(failures), &entry.Failures); err != nil {
+			return nil, err
+		}
+		entry.ResumeAt = unixTime(resumeAt)
+		if err := json.Unmarshal([]byte(options), &entry.Options); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(episodes), &entry.Episodes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+		entryMap[entry.ID] = &entry
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = r.db.Query("SELECT subscription_id, info_hash FROM history WHERE completed_at IS NOT NULL ORDER BY completed_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, infoHash string
+		if err := rows.Scan(&id, &infoHash); err != nil {
+			return nil, err
+		}
+		if entry, ok := entryMap[id]; ok {
+			entry.Completed = append(entry.Completed, infoHash)
+		}
+	}
+	return entries, rows.Err()
+}
+
+func (r *SQLiteRepo) Save(entry *worker.SubscriptionEntry) error {
+	options, err := json.Marshal(entry.Options)
+	if err != nil {
+		return err
+	}
+	episodes := entry.Episodes
+	if episodes == nil {
+		episodes = map[string]*worker.ChosenEpisode{}
+	}
+	episodesJSON, err := json.Marshal(episodes)
+	if err != nil {
+		return err
+	}
+	failures := entry.Failures
+	if failures == nil {
+		failures = map[string]*worker.Failure{}
+	}
+	failuresJSON, err := json.Marshal(failures)
+	if err != nil {
+		return err
+	}
+	var resumeAt sql.NullInt64
+	if entry.ResumeAt != nil {
+		resumeAt = sql.NullInt64{Int64: entry.ResumeAt.Unix(), Valid: true}
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO subscriptions (id, url, options, episodes, paused, resume_at, failures) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET url = excluded.url, options = excluded.options, episodes = excluded.episodes,
+			paused = excluded.paused, resume_at = excluded.resume_at, failures = excluded.failures`,
+		entry.ID, entry.RssURL, string(options), string(episodesJSON), entry.Paused, resumeAt, string(failuresJSON)); err != nil {
+		return err
+	}
+	completed, err := queryCompleted(tx, entry.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, infoHash := range entry.Completed {
+		if completed[infoHash] {
+			delete(completed, infoHash)
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO history (subscription_id, info_hash, completed_at) VALUES (?, ?, ?)
+			ON CONFLICT (subscription_id, info_hash) DO UPDATE SET completed_at = excluded.completed_at`,
+			entry.ID, infoHash, now); err != nil {
+			return err
+		}
+	}
+	// hashes no longer listed in the entry are not completed anymore
+	for infoHash := range completed {
+		if _, err := tx.Exec("UPDATE history SET completed_at = NULL WHERE subscription_id = ? AND info_hash = ?", entry.ID, infoHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func queryCompleted(tx *sql.Tx, id string) (map[string]bool, error) {
+	rows, err := tx.Query("SELECT info_hash FROM history WHERE subscription_id = ? AND completed_at IS NOT NULL", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	completed := map[string]bool{}
+	for rows.Next() {
+		var infoHash string
+		if err := rows.Scan(&infoHash); err != nil {
+			return nil, err
+		}
+		completed[infoHash] = true
+	}
+	return completed, rows.Err()
+}
+
+func (r *SQLiteRepo) Delete(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM subscriptions WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("subscription %s: %w", id, os.ErrNotExist)
+	}
+	if _, err := tx.Exec("DELETE FROM history WHERE subscription_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rename changes the id of a subscription along with its history.
+func (r *SQLiteRepo) Rename(oldID, newID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE subscriptions SET id = ? WHERE id = ?", newID, oldID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("subscription %s: %w", oldID, os.ErrNotExist)
+	}
+	if _, err := tx.Exec("UPDATE history SET subscription_id = ? WHERE subscription_id = ?", newID, oldID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteRepo) RecordAdded(id string, jobs []*poller.Job) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, job := range jobs {
+		if _, err := tx.Exec(`INSERT INTO history (subscription_id, info_hash, title, size, added_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (subscription_id, info_hash) DO UPDATE SET title = excluded.title, size = excluded.size, added_at = excluded.added_at`,
+			id, job.InfoHash, job.Title, job.Size, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteRepo) RecordCompleted(id string, infoHash string, files []string) error {
+	if files == nil {
+		files = []string{}
+	}
+	b, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`INSERT INTO history (subscription_id, info_hash, completed_at, files) VALUES (?, ?, ?, ?)
+		ON CONFLICT (subscription_id, info_hash) DO UPDATE SET completed_at = excluded.completed_at, files = excluded.files`,
+		id, infoHash, time.Now().Unix(), string(b))
+	return err
+}
+
+func (r *SQLiteRepo) History(id string) ([]*worker.HistoryItem, error) {
+	rows, err := r.db.Query(`SELECT info_hash, title, size, added_at, completed_at, files FROM history
+		WHERE subscription_id = ? ORDER BY COALESCE(added_at, completed_at) DESC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*worker.HistoryItem{}
+	for rows.Next() {
+		var item worker.HistoryItem
+		var addedAt, completedAt sql.NullInt64
+		var files string
+		if err := rows.Scan(&item.InfoHash, &item.Title, &item.Size, &addedAt, &completedAt, &files); err != nil {
+			return nil, err
+		}
+		item.AddedAt = unixTime(addedAt)
+		item.CompletedAt = unixTime(completedAt)
+		if err := json.Unmarshal([]byte(files), &item.Files); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	return items, rows.Err()
+}
+
+func unixTime(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(n.Int64, 0)
+	return &t
+}
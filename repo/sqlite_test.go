@@ -0,0 +1,272 @@
+package repo
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+	"github.com/lonord/rss-torrent-downloader/worker"
+)
+
+func querySQLite(t *testing.T, r *SQLiteRepo) map[string]*worker.SubscriptionEntry {
+	entries := map[string]*worker.SubscriptionEntry{}
+	if err := r.Query(func(entry *worker.SubscriptionEntry) {
+		entries[entry.ID] = entry
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestSQLiteRepoImport(t *testing.T) {
+	dir := t.TempDir()
+	fileRepo := &FileRepo{Dir: dir}
+	if err := fileRepo.Save(&worker.SubscriptionEntry{
+		ID:        "show",
+		RssURL:    "https://tracker.example/rss",
+		Options:   map[string]string{"filter": "1080p"},
+		Completed: []string{"aaaa", "bbbb"},
+		Episodes:  map[string]*worker.ChosenEpisode{"S01E01": {InfoHash: "aaaa"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewSQLiteRepo(filepath.Join(dir, "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	n, err := r.Import(fileRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("imported = %d; want 1", n)
+	}
+	entry := querySQLite(t, r)["show"]
+	if entry == nil {
+		t.Fatal("imported subscription not found")
+	}
+	if entry.RssURL != "https://tracker.example/rss" || entry.Options["filter"] != "1080p" {
+		t.Errorf("entry = %+v; want imported url and options", entry)
+	}
+	if c := entry.Episodes["S01E01"]; c == nil || c.InfoHash != "aaaa" {
+		t.Errorf("episodes = %v; want S01E01 chosen", entry.Episodes)
+	}
+	slices.Sort(entry.Completed)
+	if !slices.Equal(entry.Completed, []string{"aaaa", "bbbb"}) {
+		t.Errorf("completed = %v; want [aaaa bbbb]", entry.Completed)
+	}
+}
+
+func TestSQLiteRepoImportTwice(t *testing.T) {
+	dir := t.TempDir()
+	fileRepo := &FileRepo{Dir: dir}
+	if err := fileRepo.Save(&worker.SubscriptionEntry{ID: "show", RssURL: "https://tracker.example/rss", Completed: []string{"aaaa"}}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewSQLiteRepo(filepath.Join(dir, "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Import(fileRepo); err != nil {
+		t.Fatal(err)
+	}
+	// a completion recorded only in the database
+	entry := querySQLite(t, r)["show"]
+	entry.AddCompleted([]string{"bbbb"})
+	entry.Episodes = map[string]*worker.ChosenEpisode{"S01E02": {InfoHash: "bbbb"}}
+	if err := r.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	n, err := r.Import(fileRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("imported = %d; want existing subscription skipped", n)
+	}
+	entry = querySQLite(t, r)["show"]
+	slices.Sort(entry.Completed)
+	if !slices.Equal(entry.Completed, []string{"aaaa", "bbbb"}) || entry.Episodes["S01E02"] == nil {
+		t.Errorf("entry = %+v; want the completion recorded after the first import kept", entry)
+	}
+}
+
+func TestSQLiteRepoHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	r, err := NewSQLiteRepo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &worker.SubscriptionEntry{ID: "show", RssURL: "https://tracker.example/rss"}
+	if err := r.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	jobs := []*poller.Job{{InfoHash: "aaaa", Title: "Show - 01", Size: 100}, {InfoHash: "bbbb", Title: "Show - 02", Size: 200}}
+	if err := r.RecordAdded("show", jobs); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RecordCompleted("show", "aaaa", []string{"Show - 01.mkv"}); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	// reopen to make sure migrations are not applied twice
+	r, err = NewSQLiteRepo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if completed := querySQLite(t, r)["show"].Completed; !slices.Equal(completed, []string{"aaaa"}) {
+		t.Errorf("completed = %v; want [aaaa]", completed)
+	}
+	items, err := r.History("show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("len(history) = %d; want 2", len(items))
+	}
+	for _, item := range items {
+		switch item.InfoHash {
+		case "aaaa":
+			if item.Title != "Show - 01" || item.Size != 100 || item.AddedAt == nil || item.CompletedAt == nil || !slices.Equal(item.Files, []string{"Show - 01.mkv"}) {
+				t.Errorf("history aaaa = %+v; want completed item", item)
+			}
+		case "bbbb":
+			if item.AddedAt == nil || item.CompletedAt != nil {
+				t.Errorf("history bbbb = %+v; want added item", item)
+			}
+		}
+	}
+
+	if err := r.Delete("show"); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := r.History("show"); len(items) != 0 {
+		t.Errorf("history after delete = %v; want empty", items)
+	}
+	if err := r.Delete("show"); err == nil {
+		t.Error("deleting missing subscription succeeded; want error")
+	}
+}
+
+func TestSQLiteRepoRename(t *testing.T) {
+	r, err := NewSQLiteRepo(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Save(&worker.SubscriptionEntry{ID: "show", RssURL: "https://tracker.example/rss"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RecordAdded("show", []*poller.Job{{InfoHash: "aaaa", Title: "Show - 01"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Rename("show", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	entries := querySQLite(t, r)
+	if _, ok := entries["show"]; ok || entries["renamed"] == nil {
+		t.Errorf("entries after rename = %v; want only renamed", entries)
+	}
+	if items, _ := r.History("renamed"); len(items) != 1 {
+		t.Errorf("history after rename = %v; want 1 item", items)
+	}
+	if err := r.Rename("show", "again"); err == nil {
+		t.Error("renaming missing subscription succeeded; want error")
+	}
+}
+
+func TestSQLiteRepoPaused(t *testing.T) {
+	r, err := NewSQLiteRepo(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	resumeAt := time.Date(2030, 1, 2, 0, 0, 0, 0, time.Local)
+	entry := &worker.SubscriptionEntry{ID: "show", RssURL: "https://tracker.example/rss", Paused: true, ResumeAt: &resumeAt}
+	if err := r.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	got := querySQLite(t, r)["show"]
+	if !got.Paused || got.ResumeAt == nil || !got.ResumeAt.Equal(resumeAt) {
+		t.Errorf("paused = %v, resume_at = %v; want true, %v", got.Paused, got.ResumeAt, resumeAt)
+	}
+	entry.Paused, entry.ResumeAt = false, nil
+	if err := r.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	if got := querySQLite(t, r)["show"]; got.Paused || got.ResumeAt != nil {
+		t.Errorf("paused = %v, resume_at = %v; want resumed", got.Paused, got.ResumeAt)
+	}
+}
+
+func TestSQLiteRepoFailures(t *testing.T) {
+	r, err := NewSQLiteRepo(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	entry := &worker.SubscriptionEntry{ID: "show", RssURL: "https://tracker.example/rss", Failures: map[string]*worker.Failure{
+		"aaaa": {Title: "Show - 01", Attempts: 2, LastError: "invalid torrent"},
+	}}
+	if err := r.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	f := querySQLite(t, r)["show"].Failures["aaaa"]
+	if f == nil || f.Attempts != 2 || f.LastError != "invalid torrent" {
+		t.Errorf("failure = %+v; want saved failure", f)
+	}
+}
+
+func TestOpenSQLiteRepoReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.sqlite")
+	if _, err := OpenSQLiteRepoReadOnly(path); err == nil {
+		t.Error("missing database opened")
+	}
+	r, err := NewSQLiteRepo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Save(&worker.SubscriptionEntry{ID: "show", RssURL: "https://tracker.example/rss"}); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	ro, err := OpenSQLiteRepoReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if querySQLite(t, ro)["show"] == nil {
+		t.Error("subscription not readable")
+	}
+	if err := ro.Save(&worker.SubscriptionEntry{ID: "other", RssURL: "https://tracker.example/other"}); err == nil {
+		t.Error("read-only database written")
+	}
+
+	// an older schema is not migrated
+	old := filepath.Join(dir, "old.sqlite")
+	db, err := sql.Open("sqlite", "file:"+old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(migrations[0] + "PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := OpenSQLiteRepoReadOnly(old); err == nil {
+		t.Error("database with an older schema opened")
+	}
+	db, _ = sql.Open("sqlite", "file:"+old)
+	defer db.Close()
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != 1 {
+		t.Errorf("user_version = %d, %v; want 1", version, err)
+	}
+}
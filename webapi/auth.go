@@ -0,0 +1,165 @@
+package webapi
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// scopes of API keys and users, admin includes read
+const (
+	ScopeRead  = "read"
+	ScopeAdmin = "admin"
+)
+
+// Auth holds the credentials accepted by the web API, requests are not
+// authenticated if there are none.
+type Auth struct {
+	// API keys, sent as "Authorization: Bearer <key>" or "X-API-Key: <key>",
+	// and their scope
+	Keys map[string]string
+	// basic auth users
+	Users map[string]*User
+
+	// sha256 of verified basic auth credentials, bcrypt is slow by design
+	verified sync.Map
+}
+
+type User struct {
+	// bcrypt hash of the password
+	Hash  string
+	Scope string
+}
+
+// ParseKeys parses comma separated "key:scope" pairs, the scope defaults to
+// admin.
+func ParseKeys(s string) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, item := range splitList(s) {
+		key, scope, ok := strings.Cut(item, ":")
+		if !ok {
+			scope = ScopeAdmin
+		}
+		if key == "" || !validScope(scope) {
+			return nil, errors.New("invalid api key: " + item)
+		}
+		keys[key] = scope
+	}
+	return keys, nil
+}
+
+// ParseUsers parses comma separated "name:bcrypt hash:scope" entries, the
+// scope defaults to admin.
+func ParseUsers(s string) (map[string]*User, error) {
+	users := map[string]*User{}
+	for _, item := range splitList(s) {
+		parts := strings.Split(item, ":")
+		if len(parts) == 2 {
+			parts = append(parts, ScopeAdmin)
+		}
+		if len(parts) != 3 || parts[0] == "" || !validScope(parts[2]) {
+			return nil, errors.New("invalid user: " + item)
+		}
+		if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+			return nil, errors.New("invalid password hash of user " + parts[0] + ": " + err.Error())
+		}
+		users[parts[0]] = &User{Hash: parts[1], Scope: parts[2]}
+	}
+	return users, nil
+}
+
+// HashPassword returns the bcrypt hash of a password for ParseUsers.
+func HashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(b), err
+}
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func validScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeAdmin
+}
+
+func (a *Auth) enabled() bool {
+	return a != nil && (len(a.Keys) > 0 || len(a.Users) > 0)
+}
+
+// scope returns the scope of the credentials of the request, ok is false if
+// there are none or they are wrong.
+func (a *Auth) scope(r *http.Request) (string, bool) {
+	key := r.Header.Get("X-API-Key")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		key = strings.TrimPrefix(h, "Bearer ")
+	}
+	if key != "" {
+		for k, scope := range a.Keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				return scope, true
+			}
+		}
+		return "", false
+	}
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	user, ok := a.Users[name]
+	if !ok {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(name + ":" + password))
+	if hash, ok := a.verified.Load(sum); ok && hash == user.Hash {
+		return user.Scope, true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)) != nil {
+		return "", false
+	}
+	a.verified.Store(sum, user.Hash)
+	return user.Scope, true
+}
+
+// isAdmin reports whether the request is authenticated with admin scope,
+// there is no such request if no credentials are configured.
+func (a *Auth) isAdmin(r *http.Request) bool {
+	if !a.enabled() {
+		return false
+	}
+	scope, ok := a.scope(r)
+	return ok && scope == ScopeAdmin
+}
+
+// require wraps a handler to check that the request is authenticated with
+// the given scope, failures are written with writeError.
+func (a *Auth) require(scope string, h http.HandlerFunc, writeError errorWriter) http.HandlerFunc {
+	if !a.enabled() {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := a.scope(r)
+		if !ok {
+			if len(a.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="rss-torrent-downloader"`)
+			}
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if scope == ScopeAdmin && got != ScopeAdmin {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		h(w, r)
+	}
+}
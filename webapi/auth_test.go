@@ -0,0 +1,106 @@
+package webapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/repo"
+	"github.com/lonord/rss-torrent-downloader/worker"
+)
+
+func TestAuth(t *testing.T) {
+	keys, err := ParseKeys("reader:read,boss:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := ParseUsers("alice:" + hash + ":read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &HTTPServer{
+		Worker: &worker.Worker{Repo: &repo.FileRepo{Dir: t.TempDir()}},
+		Auth:   &Auth{Keys: keys, Users: users},
+	}
+	h := s.Handler()
+
+	cases := []struct {
+		name   string
+		path   string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"no credentials", "/list", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong key", "/list", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"read key", "/list", func(r *http.Request) { r.Header.Set("Authorization", "Bearer reader") }, http.StatusOK},
+		{"read key on admin route", "/dismiss", func(r *http.Request) { r.Header.Set("X-API-Key", "reader") }, http.StatusForbidden},
+		{"read key on preview", "/preview?url=http://127.0.0.1/", func(r *http.Request) { r.Header.Set("X-API-Key", "reader") }, http.StatusForbidden},
+		// passes authentication, fails for the missing parameters
+		{"admin key", "/dismiss", func(r *http.Request) { r.Header.Set("X-API-Key", "boss") }, http.StatusBadRequest},
+		{"read key on v1", "/api/v1/subscriptions", func(r *http.Request) { r.Header.Set("X-API-Key", "reader") }, http.StatusOK},
+		{"basic auth", "/list", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, http.StatusOK},
+		{"basic auth again", "/list", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, http.StatusOK},
+		{"wrong password", "/list", func(r *http.Request) { r.SetBasicAuth("alice", "guess") }, http.StatusUnauthorized},
+		{"unknown user", "/list", func(r *http.Request) { r.SetBasicAuth("bob", "secret") }, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		c.auth(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: status = %d; want %d", c.name, w.Code, c.status)
+		}
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	for _, s := range []string{"key:owner", ":read"} {
+		if _, err := ParseKeys(s); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded; want error", s)
+		}
+	}
+	for _, s := range []string{"alice:plaintext", "alice", "alice:$2a$10$x:read:extra"} {
+		if _, err := ParseUsers(s); err == nil {
+			t.Errorf("ParseUsers(%q) succeeded; want error", s)
+		}
+	}
+}
+
+func TestAuthErrorShapes(t *testing.T) {
+	s := &HTTPServer{
+		Worker: &worker.Worker{Repo: &repo.FileRepo{Dir: t.TempDir()}},
+		Auth:   &Auth{Keys: map[string]string{"reader": ScopeRead}},
+	}
+	h := s.Handler()
+	for path, want := range map[string]string{
+		"/list":                 `{"error":"unauthorized"}`,
+		"/api/v1/subscriptions": `{"error":{"code":"unauthorized","message":"unauthorized"}}`,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusUnauthorized || w.Body.String() != want {
+			t.Errorf("%s: %d %s; want 401 %s", path, w.Code, w.Body, want)
+		}
+	}
+}
+
+func TestRestrictedOptionsAdmin(t *testing.T) {
+	s := &HTTPServer{
+		Worker: &worker.Worker{Repo: &repo.FileRepo{Dir: t.TempDir()}},
+		Auth:   &Auth{Keys: map[string]string{"admin": ScopeAdmin}},
+	}
+	body := `{"id":"show","url":"http://example.com/rss","options":{"proxy":"http://127.0.0.1:8080","insecure_skip_verify":"true"}}`
+	r := httptest.NewRequest("POST", "/api/v1/subscriptions", strings.NewReader(body))
+	r.Header.Set("X-API-Key", "admin")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Errorf("status = %d, body %s; want proxy accepted from an admin key", w.Code, w.Body)
+	}
+}
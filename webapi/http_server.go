@@ -8,6 +8,10 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lonord/rss-torrent-downloader/worker"
 )
@@ -15,14 +19,123 @@ import (
 type HTTPServer struct {
 	Addr   string
 	Worker *worker.Worker
+	// optional authentication of all requests
+	Auth *Auth
+	// serve HTTPS if not nil
+	TLS *TLSConfig
 }
 
-func (s *HTTPServer) Run() {
-	http.HandleFunc("/submit", s.handleSubmit)
-	http.HandleFunc("/list", s.handleList)
-	http.HandleFunc("/add", s.handleAdd)
-	http.HandleFunc("/del", s.handleDelete)
-	http.ListenAndServe(s.Addr, nil)
+type route struct {
+	// empty for any method
+	method  string
+	pattern string
+	scope   string
+	handler http.HandlerFunc
+}
+
+func (s *HTTPServer) routes() []route {
+	return []route{
+		{"GET", "/api/v1/subscriptions", ScopeRead, s.handleListSubscriptions},
+		{"POST", "/api/v1/subscriptions", ScopeAdmin, s.handleCreateSubscription},
+		{"GET", "/api/v1/subscriptions/{id}", ScopeRead, s.handleGetSubscription},
+		{"PUT", "/api/v1/subscriptions/{id}", ScopeAdmin, s.handleReplaceSubscription},
+		{"DELETE", "/api/v1/subscriptions/{id}", ScopeAdmin, s.handleDeleteSubscription},
+		{"GET", "/openapi.json", ScopeRead, s.handleOpenAPI},
+
+		// form based endpoints, kept for compatibility
+		{"", "/submit", ScopeAdmin, s.handleSubmit},
+		{"", "/list", ScopeRead, s.handleList},
+		{"", "/add", ScopeAdmin, s.handleAdd},
+		{"", "/update", ScopeAdmin, s.handleUpdate},
+		{"", "/del", ScopeAdmin, s.handleDelete},
+		{"", "/pause", ScopeAdmin, s.handlePause},
+		{"", "/resume", ScopeAdmin, s.handleResume},
+		{"", "/history", ScopeRead, s.handleHistory},
+		{"", "/downloads", ScopeRead, s.handleDownloads},
+		{"", "/failed", ScopeRead, s.handleFailed},
+		{"", "/retry", ScopeAdmin, s.handleRetry},
+		{"", "/dismiss", ScopeAdmin, s.handleDismiss},
+		{"", "/events", ScopeRead, s.handleEvents},
+		// fetches arbitrary urls with client chosen options
+		{"", "/preview", ScopeAdmin, s.handlePreview},
+	}
+}
+
+// Handler returns the handler of all routes, each wrapped by the
+// authentication check of its scope.
+func (s *HTTPServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	// routes of a pattern by method, so that other methods get a JSON error
+	methods := map[string]map[string]http.HandlerFunc{}
+	patterns := []string{}
+	for _, r := range s.routes() {
+		h := s.Auth.require(r.scope, r.handler, errorWriterOf(r.pattern))
+		if r.method == "" {
+			mux.HandleFunc(r.pattern, h)
+			continue
+		}
+		if methods[r.pattern] == nil {
+			methods[r.pattern] = map[string]http.HandlerFunc{}
+			patterns = append(patterns, r.pattern)
+		}
+		methods[r.pattern][r.method] = h
+	}
+	for _, pattern := range patterns {
+		mux.HandleFunc(pattern, methodHandler(methods[pattern], errorWriterOf(pattern)))
+	}
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeErrorObject(w, http.StatusNotFound, "no such endpoint: "+r.URL.Path)
+	})
+	return mux
+}
+
+// errorWriter writes an error response in the shape used by a route.
+type errorWriter func(w http.ResponseWriter, status int, msg string)
+
+// errorWriterOf returns the writer of error objects for /api/v1 and of
+// {"error": msg} for the other endpoints, which existing clients expect.
+func errorWriterOf(pattern string) errorWriter {
+	if strings.HasPrefix(pattern, "/api/v1/") {
+		return writeErrorObject
+	}
+	return writeLegacyError
+}
+
+func writeLegacyError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func methodHandler(handlers map[string]http.HandlerFunc, writeError errorWriter) http.HandlerFunc {
+	allowed := make([]string, 0, len(handlers))
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	slices.Sort(allowed)
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed: "+r.Method)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *HTTPServer) Run() error {
+	server := &http.Server{
+		Addr:    s.Addr,
+		Handler: s.Handler(),
+	}
+	if s.TLS == nil {
+		return server.ListenAndServe()
+	}
+	config, err := s.TLS.build()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = config
+	return server.ListenAndServeTLS("", "")
 }
 
 func (s *HTTPServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
@@ -30,11 +143,16 @@ func (s *HTTPServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseForm(); err != nil {
 			return nil, err
 		}
-		_, rssURL, options, err := parseURLAndOptions(r.Form)
+		id, rssURL, options, err := parseURLAndOptions(r.Form)
 		if err != nil {
 			return nil, err
 		}
-		result, err := s.Worker.PollSingle(rssURL, options)
+		if err := s.validateOptions(r, options); err != nil {
+			return nil, err
+		}
+		// nothing is saved, the entry only holds the state of this poll
+		entry := &worker.SubscriptionEntry{ID: id, RssURL: rssURL, Options: options}
+		result, err := s.Worker.PollSingle(entry, false)
 		if err != nil {
 			return nil, err
 		}
@@ -46,16 +164,31 @@ func (s *HTTPServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
 func (s *HTTPServer) handleList(w http.ResponseWriter, r *http.Request) {
 	handleJSON(w, func() (interface{}, error) {
 		list := []interface{}{}
-		s.Worker.Repo.Query(func(entry *worker.SubscriptionEntry) {
+		err := s.Worker.Repo.Query(func(entry *worker.SubscriptionEntry) {
 			item := map[string]interface{}{
 				"id":        entry.ID,
 				"rss":       entry.RssURL,
 				"options":   entry.Options,
 				"completed": len(entry.Completed),
+				"paused":    entry.Paused,
+			}
+			if entry.ResumeAt != nil {
+				item["resume_at"] = entry.ResumeAt
+			}
+			if len(entry.Episodes) > 0 {
+				item["episodes"] = len(entry.Episodes)
+			}
+			if next, ok := s.Worker.NextRun(entry.ID); ok {
+				item["next_run"] = next
 			}
 			list = append(list, item)
 		})
-		return map[string]interface{}{"result": list}, nil
+		result := map[string]interface{}{"result": list}
+		if err != nil {
+			// broken subscriptions are reported along with the readable ones
+			result["error"] = err.Error()
+		}
+		return result, nil
 	})
 }
 
@@ -68,6 +201,9 @@ func (s *HTTPServer) handleAdd(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return nil, err
 		}
+		if err := s.validateOptions(r, options); err != nil {
+			return nil, err
+		}
 		entry := &worker.SubscriptionEntry{
 			ID:      id,
 			Options: options,
@@ -76,7 +212,7 @@ func (s *HTTPServer) handleAdd(w http.ResponseWriter, r *http.Request) {
 		if err := s.Worker.Repo.Save(entry); err != nil {
 			return nil, err
 		}
-		if _, err := s.Worker.PollSingle(rssURL, options); err != nil {
+		if _, err := s.Worker.PollSingle(entry, true); err != nil {
 			return nil, err
 		}
 		log.Printf("webapi: add success %s, %+v\n", rssURL, options)
@@ -84,6 +220,84 @@ func (s *HTTPServer) handleAdd(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleUpdate changes an existing subscription. The given options are
+// merged into the current ones, an option with an empty value is removed.
+// With replace=true the options are replaced as a whole. name renames the
+// subscription and url changes its feed.
+func (s *HTTPServer) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, func() (interface{}, error) {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		id := r.FormValue("id")
+		if id == "" {
+			return nil, badRequest(errors.New("missing id"))
+		}
+		replace, err := parseOptionalBool(r.FormValue("replace"))
+		if err != nil {
+			return nil, badRequest(errors.New("invalid replace: " + r.FormValue("replace")))
+		}
+		name, rssURL, options := parseUpdate(r.Form)
+		if err := s.restrictOptions(r, options); err != nil {
+			return nil, err
+		}
+		entry, err := s.Worker.UpdateEntry(id, func(entry *worker.SubscriptionEntry) error {
+			if name != "" {
+				entry.ID = name
+			}
+			if rssURL != "" {
+				entry.RssURL = rssURL
+			}
+			if replace || entry.Options == nil {
+				entry.Options = map[string]string{}
+			}
+			for k, v := range options {
+				if v == "" {
+					delete(entry.Options, k)
+				} else {
+					entry.Options[k] = v
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("webapi: update success %s, %s, %+v\n", entry.ID, entry.RssURL, entry.Options)
+		return map[string]interface{}{"result": map[string]interface{}{
+			"id":        entry.ID,
+			"rss":       entry.RssURL,
+			"options":   entry.Options,
+			"completed": len(entry.Completed),
+		}}, nil
+	})
+}
+
+func (s *HTTPServer) handlePreview(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, func() (interface{}, error) {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		id, rssURL, options, err := parseURLAndOptions(r.Form)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.validateOptions(r, options); err != nil {
+			return nil, err
+		}
+		// completed items of an existing subscription are reported as such
+		entry, err := s.Worker.FindEntry(id)
+		if err != nil {
+			return nil, err
+		}
+		preview, err := s.Worker.Preview(rssURL, options, entry)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": preview}, nil
+	})
+}
+
 func (s *HTTPServer) handleDelete(w http.ResponseWriter, r *http.Request) {
 	handleJSON(w, func() (interface{}, error) {
 		if err := r.ParseForm(); err != nil {
@@ -91,9 +305,9 @@ func (s *HTTPServer) handleDelete(w http.ResponseWriter, r *http.Request) {
 		}
 		id := r.FormValue("id")
 		if id == "" {
-			return nil, errors.New("missing id")
+			return nil, badRequest(errors.New("missing id"))
 		}
-		if err := s.Worker.Repo.Delete(id); err != nil {
+		if err := s.Worker.DeleteEntry(id); err != nil {
 			return nil, err
 		}
 		log.Printf("webapi: delete success %s\n", id)
@@ -101,22 +315,177 @@ func (s *HTTPServer) handleDelete(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func handleJSON(w http.ResponseWriter, fn func() (interface{}, error)) {
-	h := w.Header()
-	h.Set("Content-Type", "application/json; charset=UTF-8")
-	data, err := fn()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":"` + err.Error() + `"}`))
+// handlePause pauses a subscription, until is an optional date
+// (2006-01-02, local time) or RFC 3339 time to resume it automatically.
+func (s *HTTPServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, func() (interface{}, error) {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		id := r.FormValue("id")
+		if id == "" {
+			return nil, badRequest(errors.New("missing id"))
+		}
+		var until *time.Time
+		if v := r.FormValue("until"); v != "" {
+			t, err := parseDate(v)
+			if err != nil {
+				return nil, err
+			}
+			until = &t
+		}
+		if _, err := s.Worker.Pause(id, until); err != nil {
+			return nil, err
+		}
+		log.Printf("webapi: pause success %s\n", id)
+		return map[string]string{"result": "ok"}, nil
+	})
+}
+
+func (s *HTTPServer) handleResume(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, func() (interface{}, error) {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		id := r.FormValue("id")
+		if id == "" {
+			return nil, badRequest(errors.New("missing id"))
+		}
+		if _, err := s.Worker.Resume(id); err != nil {
+			return nil, err
+		}
+		log.Printf("webapi: resume success %s\n", id)
+		return map[string]string{"result": "ok"}, nil
+	})
+}
+
+func (s *HTTPServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, func() (interface{}, error) {
+		id := r.FormValue("id")
+		if id == "" {
+			return nil, badRequest(errors.New("missing id"))
+		}
+		h, ok := s.Worker.Repo.(worker.HistoryRepo)
+		if !ok {
+			return nil, errors.New("download history is not supported by subscription repo")
+		}
+		items, err := h.History(id)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": items}, nil
+	})
+}
+
+// handleDownloads reports the live progress of the downloader tasks, only
+// those of one subscription if id is given.
+func (s *HTTPServer) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, func() (interface{}, error) {
+		statuses, err := s.Worker.Downloads(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		if id := r.FormValue("id"); id != "" {
+			statuses = slices.DeleteFunc(statuses, func(st *worker.DownloadStatus) bool {
+				return st.Subscription != id
+			})
+		}
+		return map[string]interface{}{"result": statuses}, nil
+	})
+}
+
+// handleFailed lists failed items, of one subscription if id is given.
+// Dismissed items are included with all=true.
+func (s *HTTPServer) handleFailed(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, func() (interface{}, error) {
+		all, err := parseOptionalBool(r.FormValue("all"))
+		if err != nil {
+			return nil, badRequest(errors.New("invalid all: " + r.FormValue("all")))
+		}
+		items, err := s.Worker.Failures(r.FormValue("id"), all)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": items}, nil
+	})
+}
+
+func (s *HTTPServer) handleRetry(w http.ResponseWriter, r *http.Request) {
+	s.handleFailedItem(w, r, "retry", s.Worker.RetryFailed)
+}
+
+func (s *HTTPServer) handleDismiss(w http.ResponseWriter, r *http.Request) {
+	s.handleFailedItem(w, r, "dismiss", s.Worker.DismissFailed)
+}
+
+func (s *HTTPServer) handleFailedItem(w http.ResponseWriter, r *http.Request, action string, fn func(id, infoHash string) error) {
+	handleJSON(w, func() (interface{}, error) {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		id := r.FormValue("id")
+		infoHash := strings.ToLower(r.FormValue("info_hash"))
+		if id == "" || infoHash == "" {
+			return nil, badRequest(errors.New("missing id or info_hash"))
+		}
+		if err := fn(id, infoHash); err != nil {
+			return nil, err
+		}
+		log.Printf("webapi: %s success %s@%s\n", action, infoHash, id)
+		return map[string]string{"result": "ok"}, nil
+	})
+}
+
+// handleEvents streams worker events as Server-Sent Events, only those of
+// one subscription if id is given.
+func (s *HTTPServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
 		return
 	}
-	b, err := json.Marshal(data)
+	id := r.FormValue("id")
+	events, cancel := s.Worker.Events.Subscribe(64)
+	defer cancel()
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	// comments keep proxies from closing an idle stream
+	keepAlive := time.NewTicker(time.Second * 30)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+		case e := <-events:
+			if id != "" && e.Subscription != id {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write([]byte("event: " + e.Type + "\ndata: " + string(b) + "\n\n")); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func handleJSON(w http.ResponseWriter, fn func() (interface{}, error)) {
+	data, err := fn()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":"` + err.Error() + `"}`))
+		writeLegacyError(w, statusOf(err), err.Error())
 		return
 	}
-	w.Write(b)
+	writeJSON(w, http.StatusOK, data)
 }
 
 func parseURLAndOptions(form url.Values) (string, string, map[string]string, error) {
@@ -135,13 +504,21 @@ func parseURLAndOptions(form url.Values) (string, string, map[string]string, err
 			name = v[0]
 			continue
 		}
+		if k == "include" || k == "exclude" {
+			// multiple patterns are allowed, one per line
+			options[k] = strings.Join(v, "\n")
+			continue
+		}
 		options[k] = v[0]
 	}
 	if rssURL == "" {
-		return "", "", nil, errors.New("missing rss url")
+		return "", "", nil, badRequest(errors.New("missing rss url"))
 	}
 	var id string
 	if name != "" {
+		if err := validateID(name); err != nil {
+			return "", "", nil, err
+		}
 		id = name
 	} else {
 		hash := md5.Sum([]byte(rssURL))
@@ -149,3 +526,72 @@ func parseURLAndOptions(form url.Values) (string, string, map[string]string, err
 	}
 	return id, rssURL, options, nil
 }
+
+// parseUpdate reads the new name, url and options of /update, unlike
+// parseURLAndOptions empty option values are kept to remove an option.
+func parseUpdate(form url.Values) (string, string, map[string]string) {
+	options := map[string]string{}
+	var name, rssURL string
+	for k, v := range form {
+		if len(v) == 0 {
+			continue
+		}
+		switch k {
+		case "id", "replace":
+		case "name":
+			name = v[0]
+		case "rss", "url":
+			rssURL = v[0]
+		case "include", "exclude":
+			options[k] = strings.Join(v, "\n")
+		default:
+			options[k] = v[0]
+		}
+	}
+	return name, rssURL, options
+}
+
+// options which would let API clients route the requests of the server
+// elsewhere or weaken TLS verification, they are only accepted from requests
+// with admin credentials
+var restrictedOptions = []string{"proxy", "insecure_skip_verify"}
+
+func (s *HTTPServer) restrictOptions(r *http.Request, options map[string]string) error {
+	if s.Auth.isAdmin(r) {
+		return nil
+	}
+	for _, k := range restrictedOptions {
+		if options[k] != "" {
+			return badRequest(errors.New("option " + k + " requires admin credentials"))
+		}
+	}
+	return nil
+}
+
+// validateOptions checks the options given by an API client.
+func (s *HTTPServer) validateOptions(r *http.Request, options map[string]string) error {
+	if err := s.restrictOptions(r, options); err != nil {
+		return err
+	}
+	if err := s.Worker.ValidateOptions(options); err != nil {
+		return badRequest(err)
+	}
+	return nil
+}
+
+func parseOptionalBool(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, badRequest(errors.New("invalid date: " + s))
+}
@@ -0,0 +1,124 @@
+package webapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+	"github.com/lonord/rss-torrent-downloader/repo"
+	"github.com/lonord/rss-torrent-downloader/worker"
+)
+
+func TestUpdateRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "subscription")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	subRepo := &repo.FileRepo{Dir: dir}
+	if err := subRepo.Save(&worker.SubscriptionEntry{ID: "show", RssURL: "http://example.com/rss"}); err != nil {
+		t.Fatal(err)
+	}
+	s := &HTTPServer{Worker: &worker.Worker{Repo: subRepo}}
+	h := s.Handler()
+	for _, path := range []string{"/update?id=show&name=../escaped", "/update?id=show&name=.hidden", "/add?rss=http://example.com/rss&name=../escaped"} {
+		r := httptest.NewRequest("POST", path, strings.NewReader(""))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d; want 400, body %s", path, w.Code, w.Body)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "show.json")); err != nil {
+		t.Errorf("subscription moved: %v", err)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Errorf("files written outside the subscription dir: %v", entries)
+	}
+}
+
+const addFeed = `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Show</title>
+    <item>
+      <title>[G] Show - 01 [1080p]</title>
+      <link>magnet:?xt=urn:btih:0000000000000000000000000000000000000001</link>
+    </item>
+    <item>
+      <title>[G] Show - 02 [720p]</title>
+      <link>magnet:?xt=urn:btih:0000000000000000000000000000000000000002</link>
+    </item>
+    <item>
+      <title>[G] Show - 02 [1080p]</title>
+      <link>magnet:?xt=urn:btih:0000000000000000000000000000000000000003</link>
+    </item>
+  </channel>
+</rss>`
+
+// addingDown adds every job it is given.
+type addingDown struct {
+	added []string
+}
+
+func (d *addingDown) BatchDownload(ctx context.Context, works []*poller.Work) ([]downloader.DownloadResult, error) {
+	results := make([]downloader.DownloadResult, len(works))
+	for i, work := range works {
+		for _, job := range work.Jobs {
+			d.added = append(d.added, job.InfoHash)
+			results[i].Added++
+			results[i].AddedJobs = append(results[i].AddedJobs, job.InfoHash)
+		}
+	}
+	return results, nil
+}
+
+// addSubscription adds a subscription of addFeed through /add and returns
+// the jobs sent to the downloader and the saved subscription.
+func addSubscription(t *testing.T, options string) ([]string, *worker.SubscriptionEntry) {
+	t.Helper()
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(addFeed))
+	}))
+	defer feed.Close()
+	down := &addingDown{}
+	s := &HTTPServer{Worker: &worker.Worker{Repo: &repo.FileRepo{Dir: t.TempDir()}, Down: down}}
+	r := httptest.NewRequest("POST", "/add?name=show&rss="+feed.URL+"&"+options, nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	entry, err := s.Worker.FindEntry("show")
+	if err != nil || entry == nil {
+		t.Fatalf("FindEntry = %v, %v", entry, err)
+	}
+	return down.added, entry
+}
+
+func TestAddEpisodeOnce(t *testing.T) {
+	added, entry := addSubscription(t, "episode_once=true")
+	if len(added) != 2 || added[0] != "0000000000000000000000000000000000000001" {
+		t.Fatalf("added = %v; want one release of each episode", added)
+	}
+	for _, key := range []string{"S01E01", "S01E02"} {
+		if c := entry.Episodes[key]; c == nil || !slices.Contains(added, c.InfoHash) {
+			t.Errorf("episode %s = %+v; want the added release recorded", key, c)
+		}
+	}
+}
+
+func TestAddPreferredRelease(t *testing.T) {
+	added, _ := addSubscription(t, "prefer_resolution=1080p")
+	want := []string{"0000000000000000000000000000000000000001", "0000000000000000000000000000000000000003"}
+	if !slices.Equal(added, want) {
+		t.Errorf("added = %v; want only the 1080p releases %v", added, want)
+	}
+}
@@ -0,0 +1,17 @@
+package webapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI is the OpenAPI 3 document of all routes, openapi_test.go checks
+// that it stays in sync with them.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+func (s *HTTPServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write(openAPI)
+}
@@ -0,0 +1,180 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+	"github.com/lonord/rss-torrent-downloader/worker"
+)
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return &doc
+}
+
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	// methods by pattern, nil for routes accepting any method
+	routed := map[string][]string{}
+	for _, r := range (&HTTPServer{}).routes() {
+		ops := doc.Paths[r.pattern]
+		if r.method == "" {
+			routed[r.pattern] = nil
+			if !slices.ContainsFunc(httpMethods, func(m string) bool { return ops[m] != nil }) {
+				t.Errorf("%s is not documented", r.pattern)
+			}
+			continue
+		}
+		method := strings.ToLower(r.method)
+		routed[r.pattern] = append(routed[r.pattern], method)
+		if ops[method] == nil {
+			t.Errorf("%s %s is not documented", r.method, r.pattern)
+		}
+	}
+	for path, ops := range doc.Paths {
+		methods, ok := routed[path]
+		if !ok {
+			t.Errorf("%s is documented but not routed", path)
+			continue
+		}
+		for m := range ops {
+			if m == "parameters" {
+				continue
+			}
+			if !slices.Contains(httpMethods, m) || methods != nil && !slices.Contains(methods, m) {
+				t.Errorf("%s %s is documented but not routed", m, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIScopes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	for _, r := range (&HTTPServer{}).routes() {
+		for m, raw := range doc.Paths[r.pattern] {
+			if r.method != "" && m != strings.ToLower(r.method) || !slices.Contains(httpMethods, m) {
+				continue
+			}
+			var op struct {
+				Scope string `json:"x-scope"`
+			}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				t.Fatal(err)
+			}
+			if op.Scope != r.scope {
+				t.Errorf("%s %s has x-scope %q; want %q", m, r.pattern, op.Scope, r.scope)
+			}
+		}
+	}
+}
+
+func TestOpenAPIRefs(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range regexp.MustCompile(`"\$ref": "#/([^"]+)"`).FindAllSubmatch(openAPI, -1) {
+		var v interface{} = doc
+		for _, name := range strings.Split(string(m[1]), "/") {
+			obj, _ := v.(map[string]interface{})
+			v = obj[name]
+		}
+		if v == nil {
+			t.Errorf("unresolved $ref #/%s", m[1])
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	doc := loadOpenAPI(t)
+	types := map[string]interface{}{
+		"Subscription":      Subscription{},
+		"SubscriptionInput": SubscriptionInput{},
+		"SubscriptionList":  SubscriptionList{},
+		"ErrorObject":       ErrorObject{},
+		"ErrorDetail":       ErrorDetail{},
+		"DownloadResult":    downloader.DownloadResult{},
+		"HistoryItem":       worker.HistoryItem{},
+		"DownloadStatus":    worker.DownloadStatus{},
+		"FailedItem":        worker.FailedItem{},
+		"Event":             worker.Event{},
+		"Preview":           worker.Preview{},
+		"ItemResult":        poller.ItemResult{},
+	}
+	for name, v := range types {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			t.Errorf("schema %s is missing", name)
+			continue
+		}
+		want := jsonFields(reflect.TypeOf(v))
+		got := []string{}
+		for p := range schema.Properties {
+			got = append(got, p)
+		}
+		slices.Sort(want)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("schema %s has properties %v; want %v", name, got, want)
+		}
+	}
+}
+
+// jsonFields returns the JSON names of the fields of a struct type.
+func jsonFields(t reflect.Type) []string {
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			names = append(names, jsonFields(ft)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	s := &HTTPServer{Auth: &Auth{Keys: map[string]string{"reader": ScopeRead}}}
+	r := httptest.NewRequest("GET", "/openapi.json", nil)
+	r.Header.Set("X-API-Key", "reader")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !json.Valid(w.Body.Bytes()) {
+		t.Errorf("status = %d, body %.100s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,306 @@
+package webapi
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/worker"
+)
+
+// max size of a JSON request body
+const maxBodySize = 1 << 20
+
+// httpError carries the status code of an error.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+func badRequest(err error) error {
+	return &httpError{status: http.StatusBadRequest, err: err}
+}
+
+// statusOf maps an error to the status code of its response.
+func statusOf(err error) int {
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		return he.status
+	case errors.Is(err, worker.ErrNotFound), errors.Is(err, worker.ErrFailureNotFound), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, worker.ErrExists), errors.Is(err, os.ErrExist):
+		return http.StatusConflict
+	case errors.Is(err, worker.ErrInvalidID):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// error codes of the error objects, by status code
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusInternalServerError: "internal_error",
+}
+
+// ErrorObject is the body of every error response of /api/v1.
+type ErrorObject struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		status = http.StatusInternalServerError
+		b, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeErrorObject(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, ErrorObject{Error: ErrorDetail{Code: errorCodes[status], Message: msg}})
+}
+
+// handleAPI runs fn and writes its result with status, or its error as an
+// error object.
+func handleAPI(w http.ResponseWriter, status int, fn func() (interface{}, error)) {
+	data, err := fn()
+	if err != nil {
+		code := statusOf(err)
+		if code == http.StatusInternalServerError {
+			log.Printf("webapi: %s\n", err)
+		}
+		writeErrorObject(w, code, err.Error())
+		return
+	}
+	if data == nil {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, data)
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest(errors.New("invalid request body: " + err.Error()))
+	}
+	return nil
+}
+
+// validateID rejects ids which are not usable as a file name.
+func validateID(id string) error {
+	if err := worker.ValidateID(id); err != nil {
+		return badRequest(err)
+	}
+	return nil
+}
+
+// Subscription is the representation of a subscription in /api/v1.
+type Subscription struct {
+	ID        string            `json:"id"`
+	URL       string            `json:"url"`
+	Options   map[string]string `json:"options"`
+	Paused    bool              `json:"paused"`
+	ResumeAt  *time.Time        `json:"resume_at,omitempty"`
+	Completed int               `json:"completed"`
+	Episodes  int               `json:"episodes"`
+	Failed    int               `json:"failed"`
+	NextRun   *time.Time        `json:"next_run,omitempty"`
+}
+
+// SubscriptionInput is the request body of creating or replacing a
+// subscription. The id defaults to the md5 of the url on create, on replace
+// a different id renames the subscription.
+type SubscriptionInput struct {
+	ID       string            `json:"id"`
+	URL      string            `json:"url"`
+	Options  map[string]string `json:"options"`
+	Paused   bool              `json:"paused"`
+	ResumeAt *time.Time        `json:"resume_at"`
+}
+
+type SubscriptionList struct {
+	Subscriptions []*Subscription `json:"subscriptions"`
+	// set if some subscriptions could not be read
+	Error *ErrorDetail `json:"error,omitempty"`
+}
+
+func (s *HTTPServer) toSubscription(entry *worker.SubscriptionEntry) *Subscription {
+	options := entry.Options
+	if options == nil {
+		options = map[string]string{}
+	}
+	sub := &Subscription{
+		ID:        entry.ID,
+		URL:       entry.RssURL,
+		Options:   options,
+		Paused:    entry.Paused,
+		ResumeAt:  entry.ResumeAt,
+		Completed: len(entry.Completed),
+		Episodes:  len(entry.Episodes),
+	}
+	for _, f := range entry.Failures {
+		if !f.Dismissed {
+			sub.Failed++
+		}
+	}
+	if next, ok := s.Worker.NextRun(entry.ID); ok {
+		sub.NextRun = &next
+	}
+	return sub
+}
+
+func (in *SubscriptionInput) validate() error {
+	if in.URL == "" {
+		return badRequest(errors.New("missing url"))
+	}
+	if in.ResumeAt != nil && !in.Paused {
+		return badRequest(errors.New("resume_at requires paused"))
+	}
+	return nil
+}
+
+func (s *HTTPServer) handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	handleAPI(w, http.StatusOK, func() (interface{}, error) {
+		list := &SubscriptionList{Subscriptions: []*Subscription{}}
+		err := s.Worker.Repo.Query(func(entry *worker.SubscriptionEntry) {
+			list.Subscriptions = append(list.Subscriptions, s.toSubscription(entry))
+		})
+		if err != nil {
+			list.Error = &ErrorDetail{Code: errorCodes[http.StatusInternalServerError], Message: err.Error()}
+		}
+		return list, nil
+	})
+}
+
+func (s *HTTPServer) handleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	handleAPI(w, http.StatusOK, func() (interface{}, error) {
+		entry, err := s.Worker.FindEntry(r.PathValue("id"))
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, worker.ErrNotFound
+		}
+		return s.toSubscription(entry), nil
+	})
+}
+
+func (s *HTTPServer) handleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	handleAPI(w, http.StatusCreated, func() (interface{}, error) {
+		var in SubscriptionInput
+		if err := decodeBody(r, &in); err != nil {
+			return nil, err
+		}
+		if err := in.validate(); err != nil {
+			return nil, err
+		}
+		if in.ID == "" {
+			hash := md5.Sum([]byte(in.URL))
+			in.ID = hex.EncodeToString(hash[:])
+		}
+		if err := validateID(in.ID); err != nil {
+			return nil, err
+		}
+		if in.Options == nil {
+			in.Options = map[string]string{}
+		}
+		if err := s.validateOptions(r, in.Options); err != nil {
+			return nil, err
+		}
+		entry := &worker.SubscriptionEntry{
+			ID:       in.ID,
+			RssURL:   in.URL,
+			Options:  in.Options,
+			Paused:   in.Paused,
+			ResumeAt: in.ResumeAt,
+		}
+		if err := s.Worker.AddEntry(entry); err != nil {
+			return nil, err
+		}
+		log.Printf("webapi: create subscription %s, %s, %+v\n", entry.ID, entry.RssURL, entry.Options)
+		w.Header().Set("Location", "/api/v1/subscriptions/"+entry.ID)
+		return s.toSubscription(entry), nil
+	})
+}
+
+func (s *HTTPServer) handleReplaceSubscription(w http.ResponseWriter, r *http.Request) {
+	handleAPI(w, http.StatusOK, func() (interface{}, error) {
+		var in SubscriptionInput
+		if err := decodeBody(r, &in); err != nil {
+			return nil, err
+		}
+		if err := in.validate(); err != nil {
+			return nil, err
+		}
+		id := r.PathValue("id")
+		if in.ID == "" {
+			in.ID = id
+		}
+		if err := validateID(in.ID); err != nil {
+			return nil, err
+		}
+		if in.Options == nil {
+			in.Options = map[string]string{}
+		}
+		if err := s.validateOptions(r, in.Options); err != nil {
+			return nil, err
+		}
+		// completed items, chosen episodes and failures are kept
+		entry, err := s.Worker.UpdateEntry(id, func(entry *worker.SubscriptionEntry) error {
+			entry.ID = in.ID
+			entry.RssURL = in.URL
+			entry.Options = in.Options
+			entry.Paused = in.Paused
+			entry.ResumeAt = in.ResumeAt
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("webapi: replace subscription %s, %s, %+v\n", entry.ID, entry.RssURL, entry.Options)
+		return s.toSubscription(entry), nil
+	})
+}
+
+func (s *HTTPServer) handleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	handleAPI(w, http.StatusNoContent, func() (interface{}, error) {
+		id := r.PathValue("id")
+		if err := validateID(id); err != nil {
+			return nil, err
+		}
+		if err := s.Worker.DeleteEntry(id); err != nil {
+			return nil, err
+		}
+		log.Printf("webapi: delete subscription %s\n", id)
+		return nil, nil
+	})
+}
@@ -0,0 +1,87 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/repo"
+	"github.com/lonord/rss-torrent-downloader/worker"
+)
+
+func TestSubscriptionsAPI(t *testing.T) {
+	s := &HTTPServer{Worker: &worker.Worker{Repo: &repo.FileRepo{Dir: t.TempDir()}}}
+	h := s.Handler()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	expectError := func(w *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		if w.Code != status {
+			t.Fatalf("status = %d; want %d, body %s", w.Code, status, w.Body)
+		}
+		var e ErrorObject
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Error.Code != code || e.Error.Message == "" {
+			t.Errorf("error = %+v; want code %s", e.Error, code)
+		}
+	}
+
+	w := do("POST", "/api/v1/subscriptions", `{"id":"show","url":"http://example.com/rss","options":{"include":"1080p"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, body %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/v1/subscriptions/show" {
+		t.Errorf("Location = %q", loc)
+	}
+	expectError(do("POST", "/api/v1/subscriptions", `{"id":"show","url":"http://example.com/other"}`), http.StatusConflict, "conflict")
+	expectError(do("POST", "/api/v1/subscriptions", `{"id":"show"}`), http.StatusBadRequest, "bad_request")
+	expectError(do("POST", "/api/v1/subscriptions", `{"url":"http://example.com/rss","unknown":1}`), http.StatusBadRequest, "bad_request")
+	expectError(do("POST", "/api/v1/subscriptions", `{"id":"../x","url":"http://example.com/rss"}`), http.StatusBadRequest, "bad_request")
+	expectError(do("POST", "/api/v1/subscriptions", `{"url":"http://example.com/rss","options":{"proxy":"http://127.0.0.1:8080"}}`), http.StatusBadRequest, "bad_request")
+
+	w = do("GET", "/api/v1/subscriptions/show", "")
+	var sub Subscription
+	if err := json.Unmarshal(w.Body.Bytes(), &sub); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || sub.URL != "http://example.com/rss" || sub.Options["include"] != "1080p" {
+		t.Errorf("get: status = %d, %+v", w.Code, sub)
+	}
+	expectError(do("GET", "/api/v1/subscriptions/nope", ""), http.StatusNotFound, "not_found")
+
+	w = do("PUT", "/api/v1/subscriptions/show", `{"id":"renamed","url":"http://example.com/rss2"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("replace: status = %d, body %s", w.Code, w.Body)
+	}
+	expectError(do("GET", "/api/v1/subscriptions/show", ""), http.StatusNotFound, "not_found")
+	expectError(do("PUT", "/api/v1/subscriptions/nope", `{"url":"http://example.com/rss"}`), http.StatusNotFound, "not_found")
+
+	w = do("GET", "/api/v1/subscriptions", "")
+	var list SubscriptionList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Subscriptions) != 1 || list.Subscriptions[0].ID != "renamed" || len(list.Subscriptions[0].Options) != 0 {
+		t.Errorf("list = %+v", list.Subscriptions)
+	}
+
+	if w := do("DELETE", "/api/v1/subscriptions/renamed", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", w.Code)
+	}
+	expectError(do("DELETE", "/api/v1/subscriptions/renamed", ""), http.StatusNotFound, "not_found")
+
+	w = do("PATCH", "/api/v1/subscriptions/show", "")
+	expectError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	if allow := w.Header().Get("Allow"); allow != "DELETE, GET, PUT" {
+		t.Errorf("Allow = %q", allow)
+	}
+	expectError(do("GET", "/api/v1/nope", ""), http.StatusNotFound, "not_found")
+}
@@ -0,0 +1,76 @@
+package webapi
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedPersisted(t *testing.T) {
+	dir := t.TempDir()
+	c := &TLSConfig{SelfSignedDir: dir}
+	if _, err := c.build(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(filepath.Join(dir, selfSignedCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.build(); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := os.ReadFile(filepath.Join(dir, selfSignedCert))
+	if !bytes.Equal(first, second) {
+		t.Error("self-signed certificate generated again on second start")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey, err := ensureSelfSigned(filepath.Join(dir, "server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCertPEM, clientKeyPEM, err := generateSelfSigned(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCA := filepath.Join(dir, "client.pem")
+	if err := os.WriteFile(clientCA, clientCertPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := (&TLSConfig{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: clientCA}).build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	serverPEM, _ := os.ReadFile(serverCert)
+	roots.AppendCertsFromPEM(serverPEM)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	if _, err := newClient().Get(srv.URL); err == nil {
+		t.Error("request without client certificate succeeded")
+	}
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := newClient(clientCert).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
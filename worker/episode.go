@@ -0,0 +1,207 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+// Remover is implemented by downloaders which can cancel a running task and
+// delete its data, it is required for release upgrades.
+type Remover interface {
+	Remove(ctx context.Context, infoHashes []string) error
+}
+
+// ChosenEpisode is the release downloaded for an episode.
+type ChosenEpisode struct {
+	InfoHash string    `json:"info_hash"`
+	Title    string    `json:"title,omitempty"`
+	ChosenAt time.Time `json:"chosen_at"`
+}
+
+func (c *ChosenEpisode) UnmarshalJSON(b []byte) error {
+	// episodes used to be stored as a plain info hash
+	var infoHash string
+	if err := json.Unmarshal(b, &infoHash); err == nil {
+		*c = ChosenEpisode{InfoHash: infoHash}
+		return nil
+	}
+	type plain ChosenEpisode
+	return json.Unmarshal(b, (*plain)(c))
+}
+
+// EpisodeOnce reports whether each episode should be downloaded only once,
+// it is implied by an upgrade window.
+func (s *SubscriptionEntry) EpisodeOnce() bool {
+	b, _ := strconv.ParseBool(s.Options["episode_once"])
+	return b || s.UpgradeWindow() > 0
+}
+
+// UpgradeWindow returns how long after being chosen a release may still be
+// replaced by a better ranked one.
+func (s *SubscriptionEntry) UpgradeWindow() time.Duration {
+	d, _ := parseUpgradeWindow(s.Options["upgrade_window"])
+	return d
+}
+
+func parseUpgradeWindow(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.New("invalid upgrade_window: " + s)
+	}
+	return d, nil
+}
+
+// chosenHashes maps episode keys to the info hash of their chosen release.
+func (s *SubscriptionEntry) chosenHashes() map[string]string {
+	chosen := make(map[string]string, len(s.Episodes))
+	for key, c := range s.Episodes {
+		chosen[key] = c.InfoHash
+	}
+	return chosen
+}
+
+// ChooseEpisodes records the episodes of the given jobs as downloaded by
+// them, episodes already chosen are kept.
+func (s *SubscriptionEntry) ChooseEpisodes(jobs []*poller.Job, now time.Time) bool {
+	changed := false
+	for _, job := range jobs {
+		if job.Episode == nil {
+			continue
+		}
+		for _, key := range job.Episode.Keys() {
+			if _, ok := s.Episodes[key]; ok {
+				continue
+			}
+			if s.Episodes == nil {
+				s.Episodes = make(map[string]*ChosenEpisode)
+			}
+			s.Episodes[key] = &ChosenEpisode{InfoHash: job.InfoHash, Title: job.Title, ChosenAt: now}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// episodeUpgrade is a chosen release replaced by a better ranked one.
+type episodeUpgrade struct {
+	keys     []string
+	old      *ChosenEpisode
+	infoHash string
+}
+
+// filterEpisodes applies the release preference and episode tracking of the
+// subscription to a polled work, it returns the upgrades made to the entry.
+func (w *Worker) filterEpisodes(entry *SubscriptionEntry, work *poller.Work, now time.Time) []*episodeUpgrade {
+	profile, hasProfile := poller.ParseProfile(entry.Options)
+	if !entry.EpisodeOnce() {
+		if hasProfile {
+			work.KeepBestReleases(profile, nil)
+		}
+		return nil
+	}
+	var upgrades []*episodeUpgrade
+	if window := entry.UpgradeWindow(); window > 0 && hasProfile {
+		candidates := &poller.Work{Jobs: slices.Clone(work.Jobs)}
+		candidates.KeepBestReleases(profile, nil)
+		upgrades = w.upgradeEpisodes(entry, candidates, profile, window, now)
+	}
+	chosen := entry.chosenHashes()
+	if hasProfile {
+		// chosen releases are still tracked until completed, even if a
+		// better one shows up later
+		pinned := make([]string, 0, len(chosen))
+		for _, infoHash := range chosen {
+			pinned = append(pinned, infoHash)
+		}
+		work.KeepBestReleases(profile, pinned)
+	}
+	work.KeepNewEpisodes(chosen)
+	return upgrades
+}
+
+// upgradeEpisodes replaces releases chosen less than window ago and not yet
+// completed with better ranked releases of the work. The replaced releases
+// are removed from the downloader by removeUpgraded once the new ones were
+// added.
+func (w *Worker) upgradeEpisodes(entry *SubscriptionEntry, work *poller.Work, profile *poller.Profile, window time.Duration, now time.Time) []*episodeUpgrade {
+	if _, ok := w.Down.(Remover); !ok {
+		return nil
+	}
+	var upgrades []*episodeUpgrade
+	for _, job := range work.Jobs {
+		if job.Episode == nil {
+			continue
+		}
+		keys := job.Episode.Keys()
+		old := entry.Episodes[keys[0]]
+		if old == nil || old.InfoHash == job.InfoHash || now.Sub(old.ChosenAt) >= window || slices.Contains(entry.Completed, old.InfoHash) {
+			continue
+		}
+		// only replace a release covering exactly the same episodes
+		covered := 0
+		for _, c := range entry.Episodes {
+			if c.InfoHash == old.InfoHash {
+				covered++
+			}
+		}
+		sameRelease := covered == len(keys)
+		for _, key := range keys {
+			if c := entry.Episodes[key]; c == nil || c.InfoHash != old.InfoHash {
+				sameRelease = false
+			}
+		}
+		if !sameRelease || profile.Compare(job.Title, old.Title) >= 0 {
+			continue
+		}
+		log.Printf("upgrade %s of %s: %s -> %s\n", job.Episode, entry.ID, old.Title, job.Title)
+		for _, key := range keys {
+			entry.Episodes[key] = &ChosenEpisode{InfoHash: job.InfoHash, Title: job.Title, ChosenAt: old.ChosenAt}
+		}
+		upgrades = append(upgrades, &episodeUpgrade{keys: keys, old: old, infoHash: job.InfoHash})
+	}
+	return upgrades
+}
+
+// undoFailedUpgrades restores the old releases of upgrades whose new release
+// the downloader failed to add, it returns the upgrades which took effect.
+func undoFailedUpgrades(entry *SubscriptionEntry, upgrades []*episodeUpgrade, r downloader.DownloadResult) []*episodeUpgrade {
+	done := []*episodeUpgrade{}
+	for _, u := range upgrades {
+		if _, failed := r.FailedJobs[u.infoHash]; !failed {
+			done = append(done, u)
+			continue
+		}
+		log.Printf("upgrade of %s to %s failed, keeping %s\n", entry.ID, u.infoHash, u.old.InfoHash)
+		for _, key := range u.keys {
+			entry.Episodes[key] = u.old
+		}
+	}
+	return done
+}
+
+// removeUpgraded removes the replaced releases from the downloader, it is
+// called after the upgraded entry was saved.
+func (w *Worker) removeUpgraded(ctx context.Context, entry *SubscriptionEntry, upgrades []*episodeUpgrade) {
+	remover, ok := w.Down.(Remover)
+	if !ok || len(upgrades) == 0 {
+		return
+	}
+	infoHashes := make([]string, 0, len(upgrades))
+	for _, u := range upgrades {
+		infoHashes = append(infoHashes, u.old.InfoHash)
+	}
+	if err := remover.Remove(ctx, infoHashes); err != nil {
+		log.Printf("remove %v of %s for upgrade error: %s\n", infoHashes, entry.ID, err)
+	}
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+type fakeRemover struct {
+	removed []string
+}
+
+func (d *fakeRemover) BatchDownload(ctx context.Context, works []*poller.Work) ([]downloader.DownloadResult, error) {
+	return make([]downloader.DownloadResult, len(works)), nil
+}
+
+func (d *fakeRemover) Remove(ctx context.Context, infoHashes []string) error {
+	d.removed = append(d.removed, infoHashes...)
+	return nil
+}
+
+func episodeJob(infoHash, title string, n int) *poller.Job {
+	return &poller.Job{InfoHash: infoHash, Title: title, Episode: &poller.Episode{Season: 1, Start: n, End: n}}
+}
+
+func jobHashes(work *poller.Work) []string {
+	hashes := []string{}
+	for _, job := range work.Jobs {
+		hashes = append(hashes, job.InfoHash)
+	}
+	return hashes
+}
+
+func TestFilterEpisodesUpgrade(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	down := &fakeRemover{}
+	w := &Worker{Down: down}
+	entry := &SubscriptionEntry{
+		ID:      "show",
+		Options: map[string]string{"prefer_resolution": "1080p,720p", "upgrade_window": "6h"},
+		Episodes: map[string]*ChosenEpisode{
+			// still in the upgrade window
+			"S01E01": {InfoHash: "a", Title: "[G] Show - 01 [720p]", ChosenAt: now.Add(-time.Hour)},
+			// out of the upgrade window
+			"S01E02": {InfoHash: "c", Title: "[G] Show - 02 [720p]", ChosenAt: now.Add(-time.Hour * 7)},
+		},
+	}
+	work := &poller.Work{Jobs: []*poller.Job{
+		episodeJob("a", "[G] Show - 01 [720p]", 1),
+		episodeJob("b", "[G] Show - 01 [1080p]", 1),
+		episodeJob("c", "[G] Show - 02 [720p]", 2),
+		episodeJob("d", "[G] Show - 02 [1080p]", 2),
+		episodeJob("e", "[G] Show - 03 [720p]", 3),
+		episodeJob("f", "[G] Show - 03 [1080p]", 3),
+	}}
+	upgrades := w.filterEpisodes(entry, work, now)
+	if len(upgrades) != 1 {
+		t.Fatalf("upgrades = %v; want one", upgrades)
+	}
+	if len(down.removed) != 0 {
+		t.Errorf("removed = %v before the upgrade was added", down.removed)
+	}
+	if c := entry.Episodes["S01E01"]; c.InfoHash != "b" || !c.ChosenAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("S01E01 = %+v; want b chosen at the original time", c)
+	}
+	if got := jobHashes(work); !slices.Equal(got, []string{"b", "c", "f"}) {
+		t.Errorf("jobs = %v; want [b c f]", got)
+	}
+	upgraded := undoFailedUpgrades(entry, upgrades, downloader.DownloadResult{AddedJobs: []string{"b"}})
+	w.removeUpgraded(context.Background(), entry, upgraded)
+	if !slices.Equal(down.removed, []string{"a"}) {
+		t.Errorf("removed = %v; want [a]", down.removed)
+	}
+}
+
+func TestFilterEpisodesUpgradeFailed(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	down := &fakeRemover{}
+	w := &Worker{Down: down}
+	entry := &SubscriptionEntry{
+		ID:      "show",
+		Options: map[string]string{"prefer_resolution": "1080p,720p", "upgrade_window": "6h"},
+		Episodes: map[string]*ChosenEpisode{
+			"S01E01": {InfoHash: "a", Title: "[G] Show - 01 [720p]", ChosenAt: now.Add(-time.Hour)},
+		},
+	}
+	work := &poller.Work{Jobs: []*poller.Job{
+		episodeJob("a", "[G] Show - 01 [720p]", 1),
+		episodeJob("b", "[G] Show - 01 [1080p]", 1),
+	}}
+	upgrades := w.filterEpisodes(entry, work, now)
+	upgraded := undoFailedUpgrades(entry, upgrades, downloader.DownloadResult{FailedJobs: map[string]string{"b": "invalid torrent"}})
+	w.removeUpgraded(context.Background(), entry, upgraded)
+	if len(down.removed) != 0 {
+		t.Errorf("removed = %v; want old release kept", down.removed)
+	}
+	if c := entry.Episodes["S01E01"]; c.InfoHash != "a" {
+		t.Errorf("S01E01 = %+v; want a restored", c)
+	}
+}
+
+func TestFilterEpisodesCompletedNotUpgraded(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	down := &fakeRemover{}
+	w := &Worker{Down: down}
+	entry := &SubscriptionEntry{
+		ID:        "show",
+		Options:   map[string]string{"prefer_resolution": "1080p,720p", "upgrade_window": "6h"},
+		Completed: []string{"a"},
+		Episodes: map[string]*ChosenEpisode{
+			"S01E01": {InfoHash: "a", Title: "[G] Show - 01 [720p]", ChosenAt: now.Add(-time.Hour)},
+		},
+	}
+	work := &poller.Work{Jobs: []*poller.Job{
+		episodeJob("b", "[G] Show - 01 [1080p]", 1),
+	}}
+	if len(w.filterEpisodes(entry, work, now)) > 0 {
+		t.Error("completed release was upgraded")
+	}
+	if len(down.removed) != 0 || len(work.Jobs) != 0 {
+		t.Errorf("removed = %v, jobs = %v; want nothing", down.removed, jobHashes(work))
+	}
+}
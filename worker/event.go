@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// event types published by the worker
+const (
+	EventPollStarted  = "poll_started"
+	EventPollFinished = "poll_finished"
+	EventItemMatched  = "item_matched"
+	EventJobAdded     = "job_added"
+	EventJobFailed    = "job_failed"
+	EventJobCompleted = "job_completed"
+	EventScriptRan    = "script_ran"
+)
+
+// Event is something the worker did, fields not related to the type are
+// left empty.
+type Event struct {
+	Type         string    `json:"type"`
+	Time         time.Time `json:"time"`
+	Subscription string    `json:"subscription,omitempty"`
+	URL          string    `json:"url,omitempty"`
+	InfoHash     string    `json:"info_hash,omitempty"`
+	Title        string    `json:"title,omitempty"`
+	// number of matched jobs of a finished poll
+	Jobs  int      `json:"jobs,omitempty"`
+	Files []string `json:"files,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// EventBus fans out events to subscribers, the zero value is ready to use.
+// Publishing never blocks, events are dropped for subscribers which do not
+// keep up.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe returns a channel receiving all events published from now on,
+// and a function to stop the subscription which closes the channel.
+func (b *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[chan Event]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs, ch)
+			close(ch)
+		})
+	}
+}
+
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package worker
+
+import "testing"
+
+func TestEventBus(t *testing.T) {
+	var b EventBus
+	ch, cancel := b.Subscribe(1)
+	b.Publish(Event{Type: EventJobAdded, InfoHash: "aaaa"})
+	// dropped, the buffer is full
+	b.Publish(Event{Type: EventJobAdded, InfoHash: "bbbb"})
+	e := <-ch
+	if e.InfoHash != "aaaa" || e.Time.IsZero() {
+		t.Errorf("event = %+v; want aaaa with time", e)
+	}
+	cancel()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("channel not closed after cancel")
+	}
+	// no subscribers left
+	b.Publish(Event{Type: EventJobAdded})
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"net/url"
+	"sync"
+)
+
+const (
+	defaultParallelism        = 4
+	defaultPerHostParallelism = 2
+)
+
+// pollPool runs poll tasks concurrently, limited by a global parallelism and
+// a per host parallelism so that one slow tracker can not take every slot.
+type pollPool struct {
+	global  chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func newPollPool(parallelism, perHost int) *pollPool {
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+	if perHost <= 0 {
+		perHost = defaultPerHostParallelism
+	}
+	return &pollPool{
+		global:  make(chan struct{}, parallelism),
+		perHost: perHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+}
+
+func (p *pollPool) hostSem(rawURL string) chan struct{} {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sem, ok := p.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, p.perHost)
+		p.hosts[host] = sem
+	}
+	return sem
+}
+
+// run calls fn(i) for every i in [0, n) and waits for all of them to finish,
+// urlOf returns the url whose host is limited for task i.
+func (p *pollPool) run(n int, urlOf func(i int) string, fn func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// take the host slot first, so tasks waiting for a busy host do
+			// not hold global slots
+			host := p.hostSem(urlOf(i))
+			host <- struct{}{}
+			defer func() { <-host }()
+			p.global <- struct{}{}
+			defer func() { <-p.global }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPollPoolLimits(t *testing.T) {
+	urls := []string{
+		"https://a.example/1", "https://a.example/2", "https://a.example/3", "https://a.example/4",
+		"https://b.example/1", "https://b.example/2", "https://c.example/1", "https://d.example/1",
+	}
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	hostRunning, maxHostRunning := map[string]int{}, map[string]int{}
+	done := make([]bool, len(urls))
+	pool := newPollPool(3, 1)
+	pool.run(len(urls), func(i int) string {
+		return urls[i]
+	}, func(i int) {
+		host := urls[i][8:17]
+		mu.Lock()
+		running++
+		hostRunning[host]++
+		maxRunning = max(maxRunning, running)
+		maxHostRunning[host] = max(maxHostRunning[host], hostRunning[host])
+		mu.Unlock()
+		time.Sleep(time.Millisecond * 20)
+		mu.Lock()
+		running--
+		hostRunning[host]--
+		done[i] = true
+		mu.Unlock()
+	})
+	if maxRunning > 3 || maxRunning < 2 {
+		t.Errorf("max concurrent polls = %d; want 2..3", maxRunning)
+	}
+	for host, n := range maxHostRunning {
+		if n > 1 {
+			t.Errorf("max concurrent polls of %s = %d; want 1", host, n)
+		}
+	}
+	for i, d := range done {
+		if !d {
+			t.Errorf("task %d not run", i)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+// reasons of skipping a feed item added by the worker, see poller.ItemResult
+const (
+	ReasonCompleted = "completed"
+	ReasonEpisode   = "episode already downloaded"
+	ReasonRanking   = "lower ranked release"
+)
+
+// Preview is the result of a dry run of a subscription.
+type Preview struct {
+	Name  string               `json:"name"`
+	Items []*poller.ItemResult `json:"items"`
+}
+
+// Preview polls the feed and reports what would be downloaded for it without
+// touching the downloader or the repo. entry is the existing subscription
+// whose completed items and chosen episodes are taken into account, it may
+// be nil.
+func (w *Worker) Preview(rssURL string, options map[string]string, entry *SubscriptionEntry) (*Preview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
+	defer cancel()
+	work, items, err := poller.Preview(ctx, rssURL, options)
+	if err != nil {
+		return nil, err
+	}
+	e := &SubscriptionEntry{Options: options}
+	if entry != nil {
+		e.Completed = entry.Completed
+		e.Episodes = entry.Episodes
+	}
+	work.RemoveCompletedJob(e.Completed)
+	skipDropped(items, work, ReasonCompleted)
+
+	// same filters as filterEpisodes, without upgrading releases
+	chosen := e.chosenHashes()
+	if profile, ok := poller.ParseProfile(options); ok {
+		pinned := []string{}
+		if e.EpisodeOnce() {
+			for _, infoHash := range chosen {
+				pinned = append(pinned, infoHash)
+			}
+		}
+		work.KeepBestReleases(profile, pinned)
+		skipDropped(items, work, ReasonRanking)
+	}
+	if e.EpisodeOnce() {
+		work.KeepNewEpisodes(chosen)
+		skipDropped(items, work, ReasonEpisode)
+	}
+	return &Preview{Name: work.Name, Items: items}, nil
+}
+
+// skipDropped marks matched items whose job is no longer in work as skipped.
+func skipDropped(items []*poller.ItemResult, work *poller.Work, reason string) {
+	for _, item := range items {
+		if item.Matched && !slices.Contains(work.Jobs, item.Job) {
+			item.Matched = false
+			item.Reason = reason
+		}
+	}
+}
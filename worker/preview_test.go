@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const previewFeed = `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Show</title>
+    <item>
+      <title>[G] Show - 01 [720p]</title>
+      <link>magnet:?xt=urn:btih:0000000000000000000000000000000000000001</link>
+    </item>
+    <item>
+      <title>[G] Show - 02 [720p]</title>
+      <link>magnet:?xt=urn:btih:0000000000000000000000000000000000000002</link>
+    </item>
+    <item>
+      <title>[G] Show - 02 [1080p]</title>
+      <link>magnet:?xt=urn:btih:0000000000000000000000000000000000000003</link>
+    </item>
+    <item>
+      <title>[G] Show - 03 [1080p]</title>
+      <link>magnet:?xt=urn:btih:0000000000000000000000000000000000000004</link>
+    </item>
+    <item>
+      <title>[G] Show - 03 [1080p][HEVC]</title>
+      <link>magnet:?xt=urn:btih:0000000000000000000000000000000000000005</link>
+    </item>
+    <item>
+      <title>[G] Show - 04 [1080p]</title>
+      <link>https://tracker.example/view/4</link>
+    </item>
+  </channel>
+</rss>`
+
+func TestPreview(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(previewFeed))
+	}))
+	defer server.Close()
+
+	w := &Worker{}
+	options := map[string]string{"prefer_resolution": "1080p", "exclude": "HEVC", "episode_once": "true"}
+	entry := &SubscriptionEntry{
+		Completed: []string{"0000000000000000000000000000000000000001"},
+		Episodes: map[string]*ChosenEpisode{
+			"S01E01": {InfoHash: "0000000000000000000000000000000000000001"},
+			"S01E03": {InfoHash: "ffffffffffffffffffffffffffffffffffffffff"},
+		},
+	}
+	preview, err := w.Preview(server.URL, options, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Name != "Show" {
+		t.Errorf("name = %q; want Show", preview.Name)
+	}
+	want := []string{ReasonCompleted, ReasonRanking, "", ReasonEpisode, "exclude", "poller not found"}
+	if len(preview.Items) != len(want) {
+		t.Fatalf("len(items) = %d; want %d", len(preview.Items), len(want))
+	}
+	for i, item := range preview.Items {
+		if item.Reason != want[i] || item.Matched != (want[i] == "") {
+			t.Errorf("item %d %q: matched = %v, reason = %q; want reason %q", i, item.Title, item.Matched, item.Reason, want[i])
+		}
+	}
+}
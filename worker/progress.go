@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+// ProgressReporter is implemented by downloaders which can report the live
+// state of their tasks.
+type ProgressReporter interface {
+	Progress(ctx context.Context) ([]downloader.Progress, error)
+}
+
+// DownloadStatus is the progress of a download task along with the
+// subscription it was added for. Subscription is empty for tasks not
+// dispatched by the worker.
+type DownloadStatus struct {
+	Subscription string `json:"subscription,omitempty"`
+	Title        string `json:"title,omitempty"`
+	downloader.Progress
+}
+
+type trackedJob struct {
+	id    string
+	title string
+}
+
+// track remembers which subscription each dispatched job belongs to.
+func (w *Worker) track(entries []*SubscriptionEntry, works []*poller.Work) {
+	tracked := make(map[string]trackedJob)
+	for i, work := range works {
+		for _, job := range work.Jobs {
+			tracked[job.InfoHash] = trackedJob{id: entries[i].ID, title: job.Title}
+		}
+	}
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	w.tracked = tracked
+}
+
+// untracked returns the jobs of a work which were not dispatched for the
+// subscription in the last round, the newly matched ones.
+func (w *Worker) untracked(id string, work *poller.Work) []*poller.Job {
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	var jobs []*poller.Job
+	for _, job := range work.Jobs {
+		if t, ok := w.tracked[job.InfoHash]; !ok || t.id != id {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// Downloads returns the live state of the downloader tasks, mapped back to
+// subscriptions by info hash.
+func (w *Worker) Downloads(ctx context.Context) ([]*DownloadStatus, error) {
+	r, ok := w.Down.(ProgressReporter)
+	if !ok {
+		return nil, errors.New("download progress is not supported by downloader")
+	}
+	progress, err := r.Progress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	statuses := make([]*DownloadStatus, 0, len(progress))
+	for _, p := range progress {
+		job := w.tracked[p.InfoHash]
+		statuses = append(statuses, &DownloadStatus{Subscription: job.id, Title: job.title, Progress: p})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+type fakeProgress struct {
+	fakeRemover
+	progress []downloader.Progress
+}
+
+func (d *fakeProgress) Progress(ctx context.Context) ([]downloader.Progress, error) {
+	return d.progress, nil
+}
+
+func TestDownloads(t *testing.T) {
+	w := &Worker{Down: &fakeProgress{progress: []downloader.Progress{
+		{InfoHash: "aaaa", Status: "active", Completed: 50, Total: 100, Speed: 10},
+		{InfoHash: "ffff", Status: "complete"},
+	}}}
+	w.track(
+		[]*SubscriptionEntry{{ID: "show"}},
+		[]*poller.Work{{Jobs: []*poller.Job{{InfoHash: "aaaa", Title: "Show - 01"}}}},
+	)
+	statuses, err := w.Downloads(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("len(statuses) = %d; want 2", len(statuses))
+	}
+	if st := statuses[0]; st.Subscription != "show" || st.Title != "Show - 01" || st.Completed != 50 {
+		t.Errorf("status aaaa = %+v; want mapped to show", st)
+	}
+	if st := statuses[1]; st.Subscription != "" {
+		t.Errorf("status ffff = %+v; want no subscription", st)
+	}
+}
+
+func TestUntracked(t *testing.T) {
+	w := &Worker{}
+	w.track(
+		[]*SubscriptionEntry{{ID: "show"}, {ID: "other"}},
+		[]*poller.Work{{Jobs: []*poller.Job{{InfoHash: "aaaa"}}}, {Jobs: []*poller.Job{{InfoHash: "bbbb"}}}},
+	)
+	work := &poller.Work{Jobs: []*poller.Job{{InfoHash: "aaaa"}, {InfoHash: "bbbb"}, {InfoHash: "cccc"}}}
+	jobs := w.untracked("show", work)
+	if len(jobs) != 2 || jobs[0].InfoHash != "bbbb" || jobs[1].InfoHash != "cccc" {
+		t.Errorf("untracked = %v; want bbbb and cccc", jobs)
+	}
+}
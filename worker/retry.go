@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+// defaults of Worker.RetryMax and Worker.RetryBackoff
+const (
+	defaultRetryMax     = 5
+	defaultRetryBackoff = time.Minute * 10
+	maxRetryBackoff     = time.Hour * 24
+)
+
+var ErrFailureNotFound = errors.New("failed item not found")
+
+// Failure is the retry state of a job the downloader failed to add or
+// download.
+type Failure struct {
+	Title       string    `json:"title,omitempty"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	LastAttempt time.Time `json:"last_attempt"`
+	NextRetry   time.Time `json:"next_retry"`
+	// dismissed items are never retried
+	Dismissed bool `json:"dismissed,omitempty"`
+}
+
+// FailedItem is a failure along with where it belongs, as listed by
+// Worker.Failures.
+type FailedItem struct {
+	Subscription string `json:"subscription"`
+	InfoHash     string `json:"info_hash"`
+	*Failure
+	// the retry budget is used up
+	Permanent bool `json:"permanent"`
+}
+
+func (w *Worker) retryMax() int {
+	if w.RetryMax > 0 {
+		return w.RetryMax
+	}
+	return defaultRetryMax
+}
+
+func (w *Worker) retryBackoff() time.Duration {
+	if w.RetryBackoff > 0 {
+		return w.RetryBackoff
+	}
+	return defaultRetryBackoff
+}
+
+// backoff returns the delay before the next attempt, doubled after each
+// failed attempt.
+func (w *Worker) backoff(attempts int) time.Duration {
+	d := w.retryBackoff()
+	for i := 1; i < attempts && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryBackoff)
+}
+
+// skipFailed drops jobs which failed before and are not due for a retry,
+// dismissed or out of attempts.
+func (w *Worker) skipFailed(entry *SubscriptionEntry, work *poller.Work, now time.Time) {
+	if len(entry.Failures) == 0 {
+		return
+	}
+	jobs := []*poller.Job{}
+	for _, job := range work.Jobs {
+		f := entry.Failures[job.InfoHash]
+		if f != nil && (f.Dismissed || f.Attempts >= w.retryMax() || now.Before(f.NextRetry)) {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	work.Jobs = jobs
+}
+
+// removeRetried removes the tasks of the failed jobs which are retried now
+// from the downloader. qBittorrent and Transmission keep errored torrents,
+// which would be reported failed again instead of being added.
+func (w *Worker) removeRetried(ctx context.Context, entry *SubscriptionEntry, work *poller.Work) {
+	remover, ok := w.Down.(Remover)
+	if !ok || len(entry.Failures) == 0 {
+		return
+	}
+	infoHashes := []string{}
+	for _, job := range work.Jobs {
+		if entry.Failures[job.InfoHash] != nil {
+			infoHashes = append(infoHashes, job.InfoHash)
+		}
+	}
+	if len(infoHashes) == 0 {
+		return
+	}
+	if err := remover.Remove(ctx, infoHashes); err != nil {
+		log.Printf("remove %v of %s for retry error: %s\n", infoHashes, entry.ID, err)
+	}
+}
+
+// recordFailures updates the retry state from a download result, failures of
+// jobs which were added or completed are cleared.
+func (w *Worker) recordFailures(entry *SubscriptionEntry, work *poller.Work, r downloader.DownloadResult, now time.Time) bool {
+	changed := false
+	for _, infoHash := range append(r.AddedJobs, r.Completed...) {
+		if _, ok := entry.Failures[infoHash]; ok {
+			delete(entry.Failures, infoHash)
+			changed = true
+		}
+	}
+	for infoHash, msg := range r.FailedJobs {
+		if entry.Failures == nil {
+			entry.Failures = make(map[string]*Failure)
+		}
+		f := entry.Failures[infoHash]
+		if f == nil {
+			f = &Failure{}
+			if jobs := work.FindJobs([]string{infoHash}); len(jobs) > 0 {
+				f.Title = jobs[0].Title
+			}
+			entry.Failures[infoHash] = f
+		}
+		f.Attempts++
+		f.LastError = msg
+		f.LastAttempt = now
+		f.NextRetry = now.Add(w.backoff(f.Attempts))
+		changed = true
+	}
+	return changed
+}
+
+// Failures lists the failed items of a subscription, or of all
+// subscriptions if id is empty. Dismissed items are included if all is set.
+func (w *Worker) Failures(id string, all bool) ([]*FailedItem, error) {
+	items := []*FailedItem{}
+	err := w.Repo.Query(func(entry *SubscriptionEntry) {
+		if id != "" && entry.ID != id {
+			return
+		}
+		for infoHash, f := range entry.Failures {
+			if f.Dismissed && !all {
+				continue
+			}
+			items = append(items, &FailedItem{
+				Subscription: entry.ID,
+				InfoHash:     infoHash,
+				Failure:      f,
+				Permanent:    f.Attempts >= w.retryMax(),
+			})
+		}
+	})
+	return items, err
+}
+
+// RetryFailed resets the attempts of a failed item, it is retried in the
+// next round.
+func (w *Worker) RetryFailed(id, infoHash string) error {
+	_, err := w.UpdateEntry(id, func(entry *SubscriptionEntry) error {
+		f := entry.Failures[infoHash]
+		if f == nil {
+			return ErrFailureNotFound
+		}
+		f.Attempts = 0
+		f.NextRetry = time.Time{}
+		f.Dismissed = false
+		return nil
+	})
+	return err
+}
+
+// DismissFailed stops retrying a failed item.
+func (w *Worker) DismissFailed(id, infoHash string) error {
+	_, err := w.UpdateEntry(id, func(entry *SubscriptionEntry) error {
+		f := entry.Failures[infoHash]
+		if f == nil {
+			return ErrFailureNotFound
+		}
+		f.Dismissed = true
+		return nil
+	})
+	return err
+}
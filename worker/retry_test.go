@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	w := &Worker{RetryMax: 3, RetryBackoff: time.Minute}
+	entry := &SubscriptionEntry{ID: "show"}
+	newWork := func() *poller.Work {
+		return &poller.Work{Jobs: []*poller.Job{{InfoHash: "aaaa", Title: "Show - 01"}, {InfoHash: "bbbb"}}}
+	}
+	now := time.Now()
+	failed := downloader.DownloadResult{FailedJobs: map[string]string{"aaaa": "invalid torrent"}}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if !w.recordFailures(entry, newWork(), failed, now) {
+			t.Fatal("failure not recorded")
+		}
+		f := entry.Failures["aaaa"]
+		if want := time.Minute << (attempt - 1); f.Attempts != attempt || f.NextRetry.Sub(now) != want || f.Title != "Show - 01" {
+			t.Errorf("attempt %d: failure = %+v; want retry after %s", attempt, f, want)
+		}
+		work := newWork()
+		w.skipFailed(entry, work, now)
+		if hashes := jobHashes(work); !slices.Equal(hashes, []string{"bbbb"}) {
+			t.Errorf("attempt %d: jobs before retry = %v; want [bbbb]", attempt, hashes)
+		}
+		work = newWork()
+		w.skipFailed(entry, work, entry.Failures["aaaa"].NextRetry)
+		if got := len(work.Jobs); attempt < 3 && got != 2 {
+			t.Errorf("attempt %d: %d jobs when retry is due; want 2", attempt, got)
+		} else if attempt == 3 && got != 1 {
+			t.Errorf("%d jobs after budget is used up; want 1", got)
+		}
+	}
+
+	w.recordFailures(entry, newWork(), downloader.DownloadResult{AddedJobs: []string{"aaaa"}}, now)
+	if _, ok := entry.Failures["aaaa"]; ok {
+		t.Error("failure kept after the job was added")
+	}
+}
+
+func TestRemoveRetried(t *testing.T) {
+	down := &fakeRemover{}
+	w := &Worker{Down: down}
+	entry := &SubscriptionEntry{ID: "show", Failures: map[string]*Failure{"aaaa": {Attempts: 1}, "cccc": {Attempts: 1}}}
+	work := &poller.Work{Jobs: []*poller.Job{{InfoHash: "aaaa"}, {InfoHash: "bbbb"}}}
+	w.removeRetried(context.Background(), entry, work)
+	if !slices.Equal(down.removed, []string{"aaaa"}) {
+		t.Errorf("removed = %v; want [aaaa]", down.removed)
+	}
+}
+
+func TestRetryAndDismissFailed(t *testing.T) {
+	repo := memRepo{"show": {ID: "show", RssURL: "https://tracker.example/rss", Failures: map[string]*Failure{
+		"aaaa": {Attempts: 5, NextRetry: time.Now().Add(time.Hour)},
+	}}}
+	w := &Worker{Repo: repo}
+	items, err := w.Failures("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !items[0].Permanent || items[0].Subscription != "show" {
+		t.Fatalf("failures = %+v; want one permanent failure", items)
+	}
+	if err := w.RetryFailed("show", "aaaa"); err != nil {
+		t.Fatal(err)
+	}
+	if f := repo["show"].Failures["aaaa"]; f.Attempts != 0 || !f.NextRetry.IsZero() {
+		t.Errorf("failure after retry = %+v; want reset", f)
+	}
+	if err := w.DismissFailed("show", "aaaa"); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := w.Failures("show", false); len(items) != 0 {
+		t.Errorf("failures = %+v; want dismissed item hidden", items)
+	}
+	if items, _ := w.Failures("show", true); len(items) != 1 {
+		t.Errorf("all failures = %+v; want dismissed item", items)
+	}
+	if err := w.RetryFailed("show", "ffff"); !errors.Is(err, ErrFailureNotFound) {
+		t.Errorf("retry unknown item error = %v; want ErrFailureNotFound", err)
+	}
+}
@@ -0,0 +1,178 @@
+package worker
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next time a subscription is due after t.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// ParseSchedule reads the schedule of a subscription from its options.
+// "schedule" takes a 5 field cron expression, "interval" takes minutes or a
+// duration like "6h", def is used when neither is set.
+func ParseSchedule(options map[string]string, def time.Duration) (Schedule, error) {
+	if s, ok := options["schedule"]; ok && s != "" {
+		return parseCron(s)
+	}
+	if s, ok := options["interval"]; ok && s != "" {
+		d, err := parseInterval(s)
+		if err != nil {
+			return nil, err
+		}
+		return intervalSchedule(d), nil
+	}
+	return intervalSchedule(def), nil
+}
+
+func parseInterval(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return 0, errors.New("invalid interval: " + s)
+		}
+		return time.Minute * time.Duration(n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("invalid interval: " + s)
+	}
+	if d < time.Minute {
+		return 0, errors.New("interval must be at least 1m: " + s)
+	}
+	return d, nil
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+}
+
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = []cronField{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+func parseCron(s string) (*cronSchedule, error) {
+	expr := strings.TrimSpace(s)
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.New("invalid schedule, want 5 fields: " + s)
+	}
+	bits := make([]uint64, len(fields))
+	for i, f := range fields {
+		b, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, errors.New("invalid schedule " + s + ": " + err.Error())
+		}
+		bits[i] = b
+	}
+	// both 0 and 7 are sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	return &cronSchedule{minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4]}, nil
+}
+
+func parseCronField(field string, r cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, errors.New("invalid step: " + part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := r.min, r.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, errors.New("invalid value: " + part)
+			}
+			lo, hi = n, n
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.New("invalid value: " + part)
+				}
+			} else if step > 1 {
+				hi = r.max
+			}
+		}
+		if lo < r.min || hi > r.max || lo > hi {
+			return 0, errors.New("value out of range: " + part)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// give up after a few years, the expression can never match (e.g. Feb 30)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return limit
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	allDom := s.dom == fieldBits(cronFields[2])
+	allDow := s.dow == fieldBits(cronField{0, 6})
+	// like cron, a restricted day of month or day of week matches either one
+	if allDom || allDow {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func fieldBits(r cronField) uint64 {
+	var bits uint64
+	for v := r.min; v <= r.max; v++ {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
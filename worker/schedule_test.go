@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleInterval(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"30": time.Minute * 30,
+		"6h": time.Hour * 6,
+		"":   time.Hour,
+	}
+	for interval, want := range cases {
+		s, err := ParseSchedule(map[string]string{"interval": interval}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Next(now).Sub(now); got != want {
+			t.Errorf("interval %q: next after %s; want %s", interval, got, want)
+		}
+	}
+	for _, interval := range []string{"0", "-5", "10s", "weekly"} {
+		if _, err := ParseSchedule(map[string]string{"interval": interval}, time.Hour); err == nil {
+			t.Errorf("interval %q accepted; want error", interval)
+		}
+	}
+}
+
+func TestParseScheduleCron(t *testing.T) {
+	// 2024-05-01 is a wednesday
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"*/15 * * * *":  time.Date(2024, 5, 1, 12, 45, 0, 0, time.UTC),
+		"0 20 * * 6":    time.Date(2024, 5, 4, 20, 0, 0, 0, time.UTC),
+		"0 20 * * 7":    time.Date(2024, 5, 5, 20, 0, 0, 0, time.UTC),
+		"5 0-6/3 * * *": time.Date(2024, 5, 2, 0, 5, 0, 0, time.UTC),
+		"0 0 1 * *":     time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		"0 9 15 * 1":    time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC),
+		"@daily":        time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+		"0 12 29 2 *":   time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC),
+	}
+	for expr, want := range cases {
+		s, err := ParseSchedule(map[string]string{"schedule": expr, "interval": "5"}, time.Hour)
+		if err != nil {
+			t.Fatalf("schedule %q: %v", expr, err)
+		}
+		if got := s.Next(now); !got.Equal(want) {
+			t.Errorf("schedule %q: next = %s; want %s", expr, got, want)
+		}
+	}
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseSchedule(map[string]string{"schedule": expr}, time.Hour); err == nil {
+			t.Errorf("schedule %q accepted; want error", expr)
+		}
+	}
+}
@@ -3,13 +3,19 @@ package worker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/lonord/rss-torrent-downloader/downloader"
 	"github.com/lonord/rss-torrent-downloader/poller"
+	_ "github.com/lonord/rss-torrent-downloader/poller/magnet"
 	_ "github.com/lonord/rss-torrent-downloader/poller/torrent"
 )
 
@@ -22,6 +28,18 @@ type SubscriptionEntry struct {
 	RssURL    string            `json:"url"`
 	Options   map[string]string `json:"options"`
 	Completed []string          `json:"completed"`
+	// release chosen for each episode, keyed like "S01E05"
+	Episodes map[string]*ChosenEpisode `json:"episodes,omitempty"`
+	// paused subscriptions are not polled, until ResumeAt if set
+	Paused   bool       `json:"paused,omitempty"`
+	ResumeAt *time.Time `json:"resume_at,omitempty"`
+	// retry state of failed jobs by info hash
+	Failures map[string]*Failure `json:"failures,omitempty"`
+}
+
+// IsPaused reports whether the subscription is paused at the given time.
+func (s *SubscriptionEntry) IsPaused(now time.Time) bool {
+	return s.Paused && (s.ResumeAt == nil || now.Before(*s.ResumeAt))
 }
 
 func (s *SubscriptionEntry) AddCompleted(completed []string) bool {
@@ -47,47 +65,485 @@ type SubscriptionRepo interface {
 	Delete(id string) error
 }
 
+// RenameRepo is implemented by subscription repos which can change the id
+// of a subscription while keeping everything stored with it.
+type RenameRepo interface {
+	Rename(oldID, newID string) error
+}
+
+var (
+	ErrNotFound  = errors.New("subscription not found")
+	ErrExists    = errors.New("subscription already exists")
+	ErrInvalidID = errors.New("invalid id")
+)
+
+// ValidateID rejects ids which are not usable as a file name, subscriptions
+// of FileRepo are stored in a file named after their id.
+func ValidateID(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("%w: %s", ErrInvalidID, id)
+	}
+	return nil
+}
+
+// EntryError is the error of a single broken subscription, reported by
+// SubscriptionRepo.Query along with the readable ones.
+type EntryError struct {
+	ID string
+	// the error, naming where the subscription is stored
+	Err error
+}
+
+func (e *EntryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// HistoryItem is the download record of a single feed item.
+type HistoryItem struct {
+	InfoHash    string     `json:"info_hash"`
+	Title       string     `json:"title"`
+	Size        int64      `json:"size"`
+	AddedAt     *time.Time `json:"added_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Files       []string   `json:"files"`
+}
+
+// HistoryRepo is implemented by subscription repos which keep per item
+// download history.
+type HistoryRepo interface {
+	RecordAdded(id string, jobs []*poller.Job) error
+	RecordCompleted(id string, infoHash string, files []string) error
+	History(id string) ([]*HistoryItem, error)
+}
+
 type Worker struct {
 	Interval         time.Duration
 	Repo             SubscriptionRepo
 	Down             Downloader
 	OnCompleteScript string
+	// max number of feeds fetched at the same time, in total and per host
+	Parallelism        int
+	PerHostParallelism int
+	// attempts of a failed job before giving up, and the delay before the
+	// first retry which doubles after each attempt
+	RetryMax     int
+	RetryBackoff time.Duration
 
 	mu sync.Mutex
+
+	// scheduling state, guarded by schedMu so it can be read while polling
+	schedMu sync.Mutex
+	nextRun map[string]time.Time
+	// last polled work of each subscription, used to track downloads of
+	// subscriptions which are not due in this round
+	works map[string]*poller.Work
+	// subscription and title of the jobs dispatched in the last round
+	tracked map[string]trackedJob
+
+	// Events receives what the worker is doing
+	Events EventBus
+}
+
+// minSleep keeps the scheduler from spinning when a subscription is overdue.
+const minSleep = time.Second * 10
+
+// Notifier is implemented by downloaders which can push task changes, Watch
+// calls notify whenever a task completed or failed until ctx is done.
+type Notifier interface {
+	Watch(ctx context.Context, notify func())
 }
 
 func (w *Worker) Run() {
+	// a notification starts the next round at once, so completed downloads
+	// are recorded without waiting for the schedule
+	wake := make(chan struct{}, 1)
+	if n, ok := w.Down.(Notifier); ok {
+		go n.Watch(context.Background(), func() {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		})
+	}
 	for {
+		started := time.Now()
 		w.doPoll()
-		time.Sleep(w.Interval)
+		timer := time.NewTimer(w.sleepDuration(time.Now()))
+		select {
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+			// rounds started by notifications are still minSleep apart, so a
+			// burst of completions does not poll the feeds over and over
+			time.Sleep(time.Until(started.Add(minSleep)))
+		}
+	}
+}
+
+// sleepDuration returns the time until the next subscription is due, capped
+// by Interval so that downloads are still tracked regularly.
+func (w *Worker) sleepDuration(now time.Time) time.Duration {
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	d := w.Interval
+	for _, next := range w.nextRun {
+		if next.Sub(now) < d {
+			d = next.Sub(now)
+		}
+	}
+	return max(d, minSleep)
+}
+
+// NextRun returns the next time the subscription is due to be polled.
+func (w *Worker) NextRun(id string) (time.Time, bool) {
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	next, ok := w.nextRun[id]
+	return next, ok
+}
+
+// FindEntry returns the subscription with the given id, or nil if there is
+// none.
+func (w *Worker) FindEntry(id string) (*SubscriptionEntry, error) {
+	var found *SubscriptionEntry
+	err := w.Repo.Query(func(entry *SubscriptionEntry) {
+		if entry.ID == id {
+			found = entry
+		}
+	})
+	if found != nil {
+		return found, nil
+	}
+	// other subscriptions may be broken, which does not matter here
+	return nil, entryQueryError(err, id)
+}
+
+// entryQueryError drops the errors of broken subscriptions other than id from
+// a Query error.
+func entryQueryError(err error, id string) error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, err := range joined.Unwrap() {
+			if err = entryQueryError(err, id); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	var entryErr *EntryError
+	if errors.As(err, &entryErr) && entryErr.ID != id {
+		return nil
+	}
+	return err
+}
+
+// AddEntry validates and saves a new subscription, it fails with ErrExists
+// if the id is taken.
+func (w *Worker) AddEntry(entry *SubscriptionEntry) error {
+	if entry.ID == "" || entry.RssURL == "" {
+		return errors.New("missing id or rss url")
+	}
+	if err := ValidateID(entry.ID); err != nil {
+		return err
+	}
+	if err := w.ValidateOptions(entry.Options); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	existing, err := w.FindEntry(entry.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrExists
+	}
+	return w.Repo.Save(entry)
+}
+
+// DeleteEntry deletes a subscription along with its schedule and cached work,
+// without racing with a round saving it again.
+func (w *Worker) DeleteEntry(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.Repo.Delete(id); err != nil {
+		return err
+	}
+	w.forget(id)
+	return nil
+}
+
+// UpdateEntry loads the subscription with the given id, lets fn modify it
+// and saves it. fn may change the id, url and options, completion history is
+// kept. Options are validated before saving.
+func (w *Worker) UpdateEntry(id string, fn func(entry *SubscriptionEntry) error) (*SubscriptionEntry, error) {
+	// do not race with doPoll saving its copy of the entry
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entry, err := w.FindEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+	rssURL, options := entry.RssURL, maps.Clone(entry.Options)
+	if err := fn(entry); err != nil {
+		return nil, err
+	}
+	if entry.ID == "" || entry.RssURL == "" {
+		return nil, errors.New("missing id or rss url")
+	}
+	if entry.ID != id {
+		if err := ValidateID(entry.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.ValidateOptions(entry.Options); err != nil {
+		return nil, err
+	}
+	if entry.ID != id {
+		if err := w.renameEntry(id, entry); err != nil {
+			return nil, err
+		}
+	} else if err := w.Repo.Save(entry); err != nil {
+		return nil, err
+	}
+	if entry.ID != id || entry.RssURL != rssURL || !maps.Equal(entry.Options, options) {
+		// poll again with the new settings in the next round
+		w.forget(id)
+	}
+	return entry, nil
+}
+
+func (w *Worker) renameEntry(oldID string, entry *SubscriptionEntry) error {
+	existing, err := w.FindEntry(entry.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrExists
+	}
+	if r, ok := w.Repo.(RenameRepo); ok {
+		if err := r.Rename(oldID, entry.ID); err != nil {
+			return err
+		}
+		return w.Repo.Save(entry)
+	}
+	if err := w.Repo.Save(entry); err != nil {
+		return err
+	}
+	return w.Repo.Delete(oldID)
+}
+
+// Pause stops polling the subscription, until the given time if not nil.
+// Jobs already sent to the downloader are still tracked to completion.
+func (w *Worker) Pause(id string, until *time.Time) (*SubscriptionEntry, error) {
+	return w.UpdateEntry(id, func(entry *SubscriptionEntry) error {
+		entry.Paused = true
+		entry.ResumeAt = until
+		return nil
+	})
+}
+
+// Resume polls a paused subscription again from the next round.
+func (w *Worker) Resume(id string) (*SubscriptionEntry, error) {
+	return w.UpdateEntry(id, func(entry *SubscriptionEntry) error {
+		entry.Paused = false
+		entry.ResumeAt = nil
+		return nil
+	})
+}
+
+// autoResume clears the paused state of an entry whose resume time passed.
+func (w *Worker) autoResume(entry *SubscriptionEntry) {
+	entry.Paused = false
+	entry.ResumeAt = nil
+	if err := w.Repo.Save(entry); err != nil {
+		log.Printf("resume subscription %s error: %s\n", entry.ID, err)
+		return
+	}
+	log.Printf("subscription %s resumed\n", entry.ID)
+}
+
+func (w *Worker) forget(id string) {
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	delete(w.nextRun, id)
+	delete(w.works, id)
+}
+
+// ValidateOptions checks subscription options before they are saved.
+func (w *Worker) ValidateOptions(options map[string]string) error {
+	if err := poller.ValidateOptions(options); err != nil {
+		return err
+	}
+	if s, ok := options["episode_once"]; ok {
+		if _, err := strconv.ParseBool(s); err != nil {
+			return errors.New("invalid episode_once: " + s)
+		}
+	}
+	if _, err := parseUpgradeWindow(options["upgrade_window"]); err != nil {
+		return err
+	}
+	_, err := ParseSchedule(options, w.Interval)
+	return err
+}
+
+// pollEntry polls the subscription if it is due and returns its latest
+// work, polled reports whether the feed was fetched in this round.
+func (w *Worker) pollEntry(entry *SubscriptionEntry, now time.Time) (work *poller.Work, polled bool) {
+	w.schedMu.Lock()
+	if w.nextRun == nil {
+		w.nextRun = make(map[string]time.Time)
+		w.works = make(map[string]*poller.Work)
+	}
+	next, scheduled := w.nextRun[entry.ID]
+	cached := w.works[entry.ID]
+	w.schedMu.Unlock()
+	if scheduled && now.Before(next) {
+		return cloneWork(cached), false
+	}
+
+	schedule, err := ParseSchedule(entry.Options, w.Interval)
+	if err != nil {
+		log.Printf("parse schedule of %s error: %s\n", entry.ID, err)
+		schedule = intervalSchedule(w.Interval)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
+	defer cancel()
+	w.Events.Publish(Event{Type: EventPollStarted, Subscription: entry.ID, URL: entry.RssURL})
+	work, err = poller.Poll(ctx, entry.RssURL, entry.Options)
+	finished := Event{Type: EventPollFinished, Subscription: entry.ID, URL: entry.RssURL}
+	if err != nil {
+		finished.Error = err.Error()
+	} else {
+		finished.Jobs = len(work.Jobs)
+	}
+	w.Events.Publish(finished)
+
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	w.nextRun[entry.ID] = schedule.Next(now)
+	if err != nil {
+		log.Printf("poll %s error: %s\n", entry.RssURL, err)
+		return cloneWork(cached), true
+	}
+	w.works[entry.ID] = work
+	return cloneWork(work), true
+}
+
+// dispatchedWork returns the jobs of a paused subscription which were sent
+// to the downloader before, so that they are still tracked to completion
+// while the feed is not polled.
+func (w *Worker) dispatchedWork(entry *SubscriptionEntry) *poller.Work {
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	work := cloneWork(w.works[entry.ID])
+	if work == nil {
+		return nil
+	}
+	work.Jobs = slices.DeleteFunc(work.Jobs, func(job *poller.Job) bool {
+		t, ok := w.tracked[job.InfoHash]
+		return !ok || t.id != entry.ID || entry.Failures[job.InfoHash] != nil
+	})
+	return work
+}
+
+// cloneWork copies the job list, so that filtering a work for one round
+// leaves the cached work intact.
+func cloneWork(work *poller.Work) *poller.Work {
+	if work == nil {
+		return nil
+	}
+	c := *work
+	c.Jobs = slices.Clone(work.Jobs)
+	return &c
+}
+
+// forgetDeleted drops scheduling state of subscriptions no longer in repo.
+func (w *Worker) forgetDeleted(ids map[string]bool) {
+	w.schedMu.Lock()
+	defer w.schedMu.Unlock()
+	for id := range w.nextRun {
+		if !ids[id] {
+			delete(w.nextRun, id)
+			delete(w.works, id)
+		}
 	}
 }
 
 func (w *Worker) doPoll() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	allCount := 0
+	now := time.Now()
+	ids := map[string]bool{}
+	all := []*SubscriptionEntry{}
+	paused := []bool{}
+	err := w.Repo.Query(func(entry *SubscriptionEntry) {
+		ids[entry.ID] = true
+		isPaused := entry.IsPaused(now)
+		if entry.Paused && !isPaused {
+			w.autoResume(entry)
+		}
+		all = append(all, entry)
+		paused = append(paused, isPaused)
+	})
+	if err != nil {
+		log.Printf("query subscriptions error: %s\n", err)
+	} else {
+		w.forgetDeleted(ids)
+	}
+	polledWorks := make([]*poller.Work, len(all))
+	polled := make([]bool, len(all))
+	for i, entry := range all {
+		if paused[i] {
+			polledWorks[i] = w.dispatchedWork(entry)
+		}
+	}
+	pool := newPollPool(w.Parallelism, w.PerHostParallelism)
+	pool.run(len(all), func(i int) string {
+		return all[i].RssURL
+	}, func(i int) {
+		if !paused[i] {
+			polledWorks[i], polled[i] = w.pollEntry(all[i], now)
+		}
+	})
+	// keep works in repo order, results of BatchDownload are matched by index
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
+	defer cancel()
+	polledCount := 0
 	works := []*poller.Work{}
 	entries := []*SubscriptionEntry{}
-	w.Repo.Query(func(entry *SubscriptionEntry) {
-		allCount++
-		ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
-		defer cancel()
-		work, err := poller.Poll(ctx, entry.RssURL, entry.Options)
-		if err != nil {
-			log.Printf("poll %s error: %s\n", entry.RssURL, err)
-			return
-		}
-		work.RemoveCompletedJob(entry.Completed)
+	upgrades := map[string][]*episodeUpgrade{}
+	for i, entry := range all {
+		if polled[i] {
+			polledCount++
+		}
+		work := polledWorks[i]
+		if work == nil {
+			continue
+		}
+		upgrades[entry.ID] = w.filterWork(ctx, entry, work, paused[i], now)
+		if polled[i] {
+			// completed jobs are removed above, dispatched ones were
+			// reported when they first matched
+			for _, job := range w.untracked(entry.ID, work) {
+				w.Events.Publish(Event{Type: EventItemMatched, Subscription: entry.ID, InfoHash: job.InfoHash, Title: job.Title})
+			}
+		}
 		if len(work.Jobs) == 0 {
 			// all jobs are completed
-			return
+			continue
 		}
 		works = append(works, work)
 		entries = append(entries, entry)
-	})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
-	defer cancel()
+	}
+	w.track(entries, works)
 	results, err := w.Down.BatchDownload(ctx, works)
 	if err != nil {
 		log.Printf("batch download error: %s\n", err)
@@ -96,19 +552,75 @@ func (w *Worker) doPoll() {
 	log.Printf("| Add/Error/Complete | Name\n")
 	completedFiles := []string{}
 	for i, r := range results {
-		if len(r.Completed) > 0 {
-			entry := entries[i]
-			if entry.AddCompleted(r.Completed) {
-				if err := w.Repo.Save(entry); err != nil {
-					log.Println("save entry error:", err)
-				}
-			}
-		}
+		entry := entries[i]
+		w.applyResult(ctx, entry, works[i], r, upgrades[entry.ID], true, now)
 		completedFiles = append(completedFiles, r.CompletedFiles...)
 		log.Printf("| %4d / %4d / %4d | %s\n", r.Added, r.Failed, len(r.Completed), works[i].Name)
 	}
 	w.runOnCompleteScript(completedFiles)
-	log.Printf("| %d polled, %d dispatched\n", allCount, len(results))
+	log.Printf("| %d subscriptions, %d polled, %d dispatched\n", len(ids), polledCount, len(results))
+}
+
+// filterWork drops the jobs the subscription must not download in this
+// round: completed ones, failed ones waiting for a retry and releases of
+// episodes already chosen or outranked. Paused subscriptions only keep
+// tracking their dispatched jobs. It returns the chosen releases replaced by
+// better ones.
+func (w *Worker) filterWork(ctx context.Context, entry *SubscriptionEntry, work *poller.Work, paused bool, now time.Time) []*episodeUpgrade {
+	work.RemoveCompletedJob(entry.Completed)
+	w.skipFailed(entry, work, now)
+	if paused {
+		return nil
+	}
+	upgrades := w.filterEpisodes(entry, work, now)
+	w.removeRetried(ctx, entry, work)
+	return upgrades
+}
+
+// applyResult records the download result of a work in the subscription,
+// saves it if save is set and then removes the releases replaced by upgrades.
+func (w *Worker) applyResult(ctx context.Context, entry *SubscriptionEntry, work *poller.Work, r downloader.DownloadResult, upgrades []*episodeUpgrade, save bool, now time.Time) {
+	w.recordHistory(entry.ID, work, r)
+	w.publishResult(entry.ID, work, r)
+	// the old releases are only removed once the upgrades are saved
+	upgraded := undoFailedUpgrades(entry, upgrades, r)
+	changed := entry.AddCompleted(r.Completed) || len(upgrades) > 0
+	if w.recordFailures(entry, work, r, now) {
+		changed = true
+	}
+	if entry.EpisodeOnce() {
+		chosen := work.FindJobs(append(r.AddedJobs, r.Completed...))
+		if entry.ChooseEpisodes(chosen, now) {
+			changed = true
+		}
+	}
+	if !save {
+		return
+	}
+	if changed {
+		if err := w.Repo.Save(entry); err != nil {
+			log.Println("save entry error:", err)
+			upgraded = nil
+		}
+	}
+	w.removeUpgraded(ctx, entry, upgraded)
+}
+
+func (w *Worker) recordHistory(id string, work *poller.Work, r downloader.DownloadResult) {
+	h, ok := w.Repo.(HistoryRepo)
+	if !ok {
+		return
+	}
+	if len(r.AddedJobs) > 0 {
+		if err := h.RecordAdded(id, work.FindJobs(r.AddedJobs)); err != nil {
+			log.Println("record added history error:", err)
+		}
+	}
+	for _, infoHash := range r.Completed {
+		if err := h.RecordCompleted(id, infoHash, r.CompletedFileMap[infoHash]); err != nil {
+			log.Println("record completed history error:", err)
+		}
+	}
 }
 
 func (w *Worker) runOnCompleteScript(completedFiles []string) {
@@ -120,20 +632,47 @@ func (w *Worker) runOnCompleteScript(completedFiles []string) {
 	if len(out) > 0 {
 		log.Printf("on complete script output: %s", out)
 	}
+	e := Event{Type: EventScriptRan, Files: completedFiles}
 	if err != nil {
 		log.Printf("on complete script %s error: %s\n", w.OnCompleteScript, err)
+		e.Error = err.Error()
+	}
+	w.Events.Publish(e)
+}
+
+// publishResult publishes the job events of a download result.
+func (w *Worker) publishResult(id string, work *poller.Work, r downloader.DownloadResult) {
+	title := func(infoHash string) string {
+		if jobs := work.FindJobs([]string{infoHash}); len(jobs) > 0 {
+			return jobs[0].Title
+		}
+		return ""
+	}
+	for _, infoHash := range r.AddedJobs {
+		w.Events.Publish(Event{Type: EventJobAdded, Subscription: id, InfoHash: infoHash, Title: title(infoHash)})
+	}
+	for infoHash, msg := range r.FailedJobs {
+		w.Events.Publish(Event{Type: EventJobFailed, Subscription: id, InfoHash: infoHash, Title: title(infoHash), Error: msg})
+	}
+	for _, infoHash := range r.Completed {
+		w.Events.Publish(Event{Type: EventJobCompleted, Subscription: id, InfoHash: infoHash, Title: title(infoHash), Files: r.CompletedFileMap[infoHash]})
 	}
 }
 
-func (w *Worker) PollSingle(rssURL string, options map[string]string) (downloader.DownloadResult, error) {
+// PollSingle polls the feed of a subscription once and sends its jobs to the
+// downloader, filtered like in a round. The result is recorded in entry, which
+// is saved if save is set, and the download history is recorded under its id.
+func (w *Worker) PollSingle(entry *SubscriptionEntry, save bool) (downloader.DownloadResult, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	now := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
-	work, err := poller.Poll(ctx, rssURL, options)
+	work, err := poller.Poll(ctx, entry.RssURL, entry.Options)
 	if err != nil {
 		return downloader.DownloadResult{}, err
 	}
+	upgrades := w.filterWork(ctx, entry, work, false, now)
 	results, err := w.Down.BatchDownload(ctx, []*poller.Work{work})
 	if err != nil {
 		return downloader.DownloadResult{}, err
@@ -141,6 +680,7 @@ func (w *Worker) PollSingle(rssURL string, options map[string]string) (downloade
 	if len(results) != 1 {
 		return downloader.DownloadResult{}, errors.New("unexpected result count")
 	}
+	w.applyResult(ctx, entry, work, results[0], upgrades, save, now)
 	w.runOnCompleteScript(results[0].CompletedFiles)
 	return results[0], nil
 }
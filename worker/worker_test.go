@@ -0,0 +1,241 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lonord/rss-torrent-downloader/downloader"
+	"github.com/lonord/rss-torrent-downloader/poller"
+)
+
+// memRepo is a SubscriptionRepo without rename support.
+type memRepo map[string]*SubscriptionEntry
+
+func (r memRepo) Query(fn func(entry *SubscriptionEntry)) error {
+	for _, id := range slices.Sorted(maps.Keys(r)) {
+		e := *r[id]
+		e.Options = maps.Clone(e.Options)
+		fn(&e)
+	}
+	return nil
+}
+
+func (r memRepo) Save(entry *SubscriptionEntry) error {
+	e := *entry
+	r[entry.ID] = &e
+	return nil
+}
+
+func (r memRepo) Delete(id string) error {
+	if _, ok := r[id]; !ok {
+		return os.ErrNotExist
+	}
+	delete(r, id)
+	return nil
+}
+
+func TestUpdateEntry(t *testing.T) {
+	repo := memRepo{
+		"show":  {ID: "show", RssURL: "https://tracker.example/rss", Options: map[string]string{"filter": "1080p"}, Completed: []string{"aaaa"}},
+		"other": {ID: "other", RssURL: "https://tracker.example/other"},
+	}
+	w := &Worker{Repo: repo}
+	w.nextRun = map[string]time.Time{"show": time.Now().Add(time.Hour)}
+
+	entry, err := w.UpdateEntry("show", func(entry *SubscriptionEntry) error {
+		entry.ID = "renamed"
+		entry.RssURL = "https://tracker.example/new"
+		entry.Options["size"] = "100"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repo["show"]; ok {
+		t.Error("old entry still exists after rename")
+	}
+	saved := repo["renamed"]
+	if saved == nil || saved.RssURL != entry.RssURL || saved.Options["filter"] != "1080p" || saved.Options["size"] != "100" || !slices.Equal(saved.Completed, []string{"aaaa"}) {
+		t.Errorf("saved entry = %+v; want renamed entry keeping options and completed", saved)
+	}
+	if _, ok := w.NextRun("show"); ok {
+		t.Error("schedule of updated entry not reset")
+	}
+
+	if _, err := w.UpdateEntry("renamed", func(entry *SubscriptionEntry) error {
+		entry.ID = "other"
+		return nil
+	}); !errors.Is(err, ErrExists) {
+		t.Errorf("rename to existing id error = %v; want ErrExists", err)
+	}
+	if _, err := w.UpdateEntry("missing", func(entry *SubscriptionEntry) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update missing error = %v; want ErrNotFound", err)
+	}
+	if _, err := w.UpdateEntry("renamed", func(entry *SubscriptionEntry) error {
+		entry.Options["include"] = "("
+		return nil
+	}); err == nil {
+		t.Error("invalid options saved")
+	}
+	if repo["renamed"].Options["include"] != "" {
+		t.Error("invalid options written to repo")
+	}
+}
+
+// completingDown completes every job it is given.
+type completingDown struct {
+	works []*poller.Work
+}
+
+func (d *completingDown) BatchDownload(ctx context.Context, works []*poller.Work) ([]downloader.DownloadResult, error) {
+	d.works = append(d.works, works...)
+	results := make([]downloader.DownloadResult, len(works))
+	for i, work := range works {
+		for _, job := range work.Jobs {
+			results[i].Completed = append(results[i].Completed, job.InfoHash)
+		}
+	}
+	return results, nil
+}
+
+func TestPausedTracksDispatchedJobs(t *testing.T) {
+	repo := memRepo{"show": {ID: "show", RssURL: "https://tracker.example/rss"}}
+	down := &completingDown{}
+	w := &Worker{Repo: repo, Down: down, Interval: time.Hour}
+	w.nextRun = map[string]time.Time{"show": time.Now().Add(time.Hour)}
+	w.works = map[string]*poller.Work{"show": {Name: "show", Jobs: []*poller.Job{{InfoHash: "aaaa"}, {InfoHash: "bbbb"}}}}
+	w.tracked = map[string]trackedJob{"aaaa": {id: "show"}}
+	if _, err := w.Pause("show", nil); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := w.Events.Subscribe(16)
+	defer cancel()
+	w.doPoll()
+	if len(down.works) != 1 || !slices.Equal(jobHashes(down.works[0]), []string{"aaaa"}) {
+		t.Fatalf("dispatched = %v; want only the dispatched job aaaa", down.works)
+	}
+	if entry := repo["show"]; !slices.Equal(entry.Completed, []string{"aaaa"}) || !entry.Paused {
+		t.Errorf("entry = %+v; want aaaa completed and still paused", entry)
+	}
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventPollStarted {
+			t.Error("paused subscription polled")
+		}
+	}
+}
+
+// historyRepo records the completed jobs of each subscription.
+type historyRepo struct {
+	memRepo
+	completed map[string][]string
+}
+
+func (r *historyRepo) RecordAdded(id string, jobs []*poller.Job) error {
+	return nil
+}
+
+func (r *historyRepo) RecordCompleted(id string, infoHash string, files []string) error {
+	r.completed[id] = append(r.completed[id], infoHash)
+	return nil
+}
+
+func (r *historyRepo) History(id string) ([]*HistoryItem, error) {
+	return nil, nil
+}
+
+func TestPollSingleRecordsHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(previewFeed))
+	}))
+	defer server.Close()
+
+	repo := &historyRepo{memRepo: memRepo{}, completed: map[string][]string{}}
+	w := &Worker{Repo: repo, Down: &completingDown{}}
+	r, err := w.PollSingle(&SubscriptionEntry{ID: "show", RssURL: server.URL}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Completed) == 0 || !slices.Equal(repo.completed["show"], r.Completed) {
+		t.Errorf("history = %v; want %v recorded under show", repo.completed, r.Completed)
+	}
+}
+
+// brokenRepo reports broken subscriptions along with the readable ones.
+type brokenRepo struct {
+	memRepo
+	broken []string
+}
+
+func (r brokenRepo) Query(fn func(entry *SubscriptionEntry)) error {
+	r.memRepo.Query(fn)
+	var errs []error
+	for _, id := range r.broken {
+		errs = append(errs, &EntryError{ID: id, Err: errors.New(id + ".json: unexpected end of JSON input")})
+	}
+	return errors.Join(errs...)
+}
+
+func TestFindEntryBrokenRepo(t *testing.T) {
+	w := &Worker{Repo: brokenRepo{
+		memRepo: memRepo{"show": {ID: "show", RssURL: "https://tracker.example/rss"}},
+		broken:  []string{"broken", "other"},
+	}}
+	if entry, err := w.FindEntry("show"); err != nil || entry == nil {
+		t.Errorf("FindEntry(show) = %v, %v; want entry", entry, err)
+	}
+	if entry, err := w.FindEntry("new"); err != nil || entry != nil {
+		t.Errorf("FindEntry(new) = %v, %v; want nil, nil", entry, err)
+	}
+	if _, err := w.FindEntry("broken"); err == nil || !strings.Contains(err.Error(), "broken.json") || strings.Contains(err.Error(), "other.json") {
+		t.Errorf("FindEntry(broken) error = %v; want only its own error", err)
+	}
+	if err := w.AddEntry(&SubscriptionEntry{ID: "new", RssURL: "https://tracker.example/new"}); err != nil {
+		t.Errorf("AddEntry with other broken subscriptions: %v", err)
+	}
+}
+
+func TestDeleteEntry(t *testing.T) {
+	repo := memRepo{"show": {ID: "show", RssURL: "https://tracker.example/rss"}}
+	w := &Worker{Repo: repo}
+	w.nextRun = map[string]time.Time{"show": time.Now()}
+	w.works = map[string]*poller.Work{"show": {}}
+	if err := w.DeleteEntry("show"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repo["show"]; ok {
+		t.Error("entry not deleted")
+	}
+	if _, ok := w.NextRun("show"); ok || w.works["show"] != nil {
+		t.Error("schedule or cached work kept")
+	}
+	if err := w.DeleteEntry("show"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("second delete error = %v; want not exist", err)
+	}
+}
+
+func TestIsPaused(t *testing.T) {
+	now := time.Now()
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+	cases := []struct {
+		entry SubscriptionEntry
+		want  bool
+	}{
+		{SubscriptionEntry{}, false},
+		{SubscriptionEntry{Paused: true}, true},
+		{SubscriptionEntry{Paused: true, ResumeAt: &later}, true},
+		{SubscriptionEntry{Paused: true, ResumeAt: &earlier}, false},
+	}
+	for _, c := range cases {
+		if got := c.entry.IsPaused(now); got != c.want {
+			t.Errorf("IsPaused(%+v) = %v; want %v", c.entry, got, c.want)
+		}
+	}
+}